@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+The ConcreteMediator calls Receive synchronously, so a slow colleague holds up
+the sender and every colleague after it, and a colleague that sends from
+inside Receive recurses straight back into the mediator.
+
+The AsyncMediator gives every colleague a bounded mailbox drained by its own
+goroutine. Sending only enqueues, so the sender returns immediately and events
+from one sender reach each recipient in the order they were sent.
+
+When a mailbox is full the overflow policy decides what happens:
+
+	Block       the sender waits for room in the mailbox
+	DropOldest  the oldest queued event is discarded to make room
+	Reject      the new event is discarded and the sender gets ErrMailboxFull
+
+With Block, a colleague that sends to itself or a cycle of colleagues that
+send to each other from Receive can deadlock once their mailboxes fill up;
+use DropOldest or Reject for such topologies.
+*/
+
+var (
+	ErrMailboxFull     = errors.New("mailbox is full")
+	ErrMediatorClosed  = errors.New("mediator is closed")
+	ErrMailboxNotFound = errors.New("mailbox not found")
+)
+
+type OverflowPolicy int
+
+const (
+	Block OverflowPolicy = iota
+	DropOldest
+	Reject
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop oldest"
+	case Reject:
+		return "reject"
+	}
+	return "unknown"
+}
+
+type MailboxStats struct {
+	Queued    int
+	Delivered int
+	Dropped   int
+	Rejected  int
+}
+
+type envelope struct {
+	from  string
+	event string
+}
+
+type mailbox struct {
+	colleague Colleague
+	capacity  int
+	policy    OverflowPolicy
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	queue    []envelope
+	closed   bool
+	stats    MailboxStats
+	done     chan struct{}
+}
+
+func newMailbox(c Colleague, capacity int, policy OverflowPolicy) *mailbox {
+	box := &mailbox{
+		colleague: c,
+		capacity:  capacity,
+		policy:    policy,
+		done:      make(chan struct{}),
+	}
+	box.notEmpty = sync.NewCond(&box.mu)
+	box.notFull = sync.NewCond(&box.mu)
+	go box.run()
+	return box
+}
+
+func (b *mailbox) put(env envelope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for !b.closed && len(b.queue) >= b.capacity {
+		switch b.policy {
+		case DropOldest:
+			b.queue = b.queue[1:]
+			b.stats.Dropped++
+		case Reject:
+			b.stats.Rejected++
+			return ErrMailboxFull
+		default:
+			b.notFull.Wait()
+		}
+	}
+	if b.closed {
+		b.stats.Rejected++
+		return ErrMediatorClosed
+	}
+
+	b.queue = append(b.queue, env)
+	b.notEmpty.Signal()
+	return nil
+}
+
+// run delivers queued events one at a time until the mailbox is closed and
+// empty.
+func (b *mailbox) run() {
+	defer close(b.done)
+	for {
+		b.mu.Lock()
+		for len(b.queue) == 0 && !b.closed {
+			b.notEmpty.Wait()
+		}
+		if len(b.queue) == 0 {
+			b.mu.Unlock()
+			return
+		}
+		env := b.queue[0]
+		b.queue = b.queue[1:]
+		b.notFull.Signal()
+		b.mu.Unlock()
+
+		b.colleague.Receive(env.from, env.event)
+
+		b.mu.Lock()
+		b.stats.Delivered++
+		b.mu.Unlock()
+	}
+}
+
+// close stops accepting events. Events already queued are still delivered.
+func (b *mailbox) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.notEmpty.Broadcast()
+	b.notFull.Broadcast()
+	b.mu.Unlock()
+}
+
+func (b *mailbox) snapshot() MailboxStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Queued = len(b.queue)
+	return stats
+}
+
+// AsyncMediator routes like ConcreteMediator but delivers through mailboxes.
+type AsyncMediator struct {
+	*ConcreteMediator
+
+	capacity int
+	policy   OverflowPolicy
+
+	mu        sync.Mutex
+	mailboxes map[string]*mailbox
+	closed    bool
+}
+
+func NewAsyncMediator(capacity int, policy OverflowPolicy) *AsyncMediator {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &AsyncMediator{
+		ConcreteMediator: NewConcreteMediator(),
+		capacity:         capacity,
+		policy:           policy,
+		mailboxes:        make(map[string]*mailbox),
+	}
+}
+
+func (m *AsyncMediator) Register(name string, c Colleague) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrMediatorClosed
+	}
+	if err := m.ConcreteMediator.register(name, c, m); err != nil {
+		return err
+	}
+	m.mailboxes[name] = newMailbox(c, m.capacity, m.policy)
+	return nil
+}
+
+// Unregister removes the colleague right away; events already in its
+// mailbox are still delivered in the background.
+func (m *AsyncMediator) Unregister(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.ConcreteMediator.Unregister(name); err != nil {
+		return err
+	}
+	if box, ok := m.mailboxes[name]; ok {
+		box.close()
+		delete(m.mailboxes, name)
+	}
+	return nil
+}
+
+func (m *AsyncMediator) notify(from *member, event string) {
+	if names, err := m.broadcastRoute(from); err == nil {
+		m.enqueue(from.name, names, event)
+	}
+}
+
+func (m *AsyncMediator) publish(from *member, topic, event string) error {
+	names, err := m.topicRoute(from, topic)
+	if err != nil {
+		return err
+	}
+	return m.enqueue(from.name, names, event)
+}
+
+func (m *AsyncMediator) sendTo(from *member, recipient, event string) error {
+	names, err := m.directRoute(from, recipient)
+	if err != nil {
+		return err
+	}
+	return m.enqueue(from.name, names, event)
+}
+
+// Stats reports the mailbox counters of a registered colleague. The counters
+// remain available after Close.
+func (m *AsyncMediator) Stats(name string) (MailboxStats, error) {
+	m.mu.Lock()
+	box, ok := m.mailboxes[name]
+	m.mu.Unlock()
+	if !ok {
+		return MailboxStats{}, fmt.Errorf("%w: %q", ErrMailboxNotFound, name)
+	}
+	return box.snapshot(), nil
+}
+
+// Close stops accepting new events and waits until every mailbox has
+// delivered what was queued before. Events sent from Receive while draining
+// are rejected with ErrMediatorClosed.
+func (m *AsyncMediator) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrMediatorClosed
+	}
+	m.closed = true
+	boxes := make([]*mailbox, 0, len(m.mailboxes))
+	for _, box := range m.mailboxes {
+		box.close()
+		boxes = append(boxes, box)
+	}
+	m.mu.Unlock()
+
+	for _, box := range boxes {
+		<-box.done
+	}
+	return nil
+}
+
+// enqueue puts the event in each recipient's mailbox. A full mailbox never
+// prevents delivery to the others; the failures are joined into one error.
+func (m *AsyncMediator) enqueue(from string, names []string, event string) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrMediatorClosed
+	}
+	boxes := make(map[string]*mailbox, len(names))
+	for _, name := range names {
+		if box, ok := m.mailboxes[name]; ok {
+			boxes[name] = box
+		}
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, name := range names {
+		box, ok := boxes[name]
+		if !ok {
+			continue
+		}
+		if err := box.put(envelope{from: from, event: event}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gated holds every event in Receive until release is closed, and signals
+// entered when the first one arrives.
+type gated struct {
+	*recorder
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newGated(name string) *gated {
+	return &gated{
+		recorder: newRecorder(name),
+		entered:  make(chan struct{}, 1),
+		release:  make(chan struct{}),
+	}
+}
+
+func (g *gated) Receive(from, event string) {
+	select {
+	case g.entered <- struct{}{}:
+	default:
+	}
+	<-g.release
+	g.recorder.Receive(from, event)
+}
+
+// blockedMailbox registers a gated bob with a mailbox of the given capacity
+// and has alice send him one event, which he holds on to. It returns alice's
+// handle for sending more.
+func blockedMailbox(t *testing.T, capacity int, policy OverflowPolicy) (*AsyncMediator, Mediator, *gated) {
+	t.Helper()
+	m := NewAsyncMediator(capacity, policy)
+	bob := newGated("bob")
+	alice := newRecorder("alice")
+	m.Register("alice", alice)
+	m.Register("bob", bob)
+	if err := alice.mediator.SendTo("bob", "event 1"); err != nil {
+		t.Fatal(err)
+	}
+	<-bob.entered
+	return m, alice.mediator, bob
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	m, alice, bob := blockedMailbox(t, 2, DropOldest)
+	for i := 2; i <= 5; i++ {
+		if err := alice.SendTo("bob", fmt.Sprintf("event %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(bob.release)
+	m.Close()
+
+	want := []string{"alice: event 1", "alice: event 4", "alice: event 5"}
+	if got := bob.events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("bob received %v, want %v", got, want)
+	}
+	stats, err := m.Stats("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (MailboxStats{Delivered: 3, Dropped: 2}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestOverflowReject(t *testing.T) {
+	m, alice, bob := blockedMailbox(t, 2, Reject)
+	for i := 2; i <= 5; i++ {
+		err := alice.SendTo("bob", fmt.Sprintf("event %d", i))
+		if full := i > 3; full != errors.Is(err, ErrMailboxFull) {
+			t.Errorf("event %d: got error %v", i, err)
+		}
+	}
+	close(bob.release)
+	m.Close()
+
+	want := []string{"alice: event 1", "alice: event 2", "alice: event 3"}
+	if got := bob.events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("bob received %v, want %v", got, want)
+	}
+	stats, _ := m.Stats("bob")
+	if want := (MailboxStats{Delivered: 3, Rejected: 2}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	m, alice, bob := blockedMailbox(t, 1, Block)
+	if err := alice.SendTo("bob", "event 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := make(chan error, 1)
+	go func() { sent <- alice.SendTo("bob", "event 3") }()
+	select {
+	case err := <-sent:
+		t.Fatalf("send into a full mailbox returned early: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(bob.release)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	want := []string{"alice: event 1", "alice: event 2", "alice: event 3"}
+	if got := bob.events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("bob received %v, want %v", got, want)
+	}
+	stats, _ := m.Stats("bob")
+	if want := (MailboxStats{Delivered: 3}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestPerSenderOrdering(t *testing.T) {
+	const events = 200
+	m := NewAsyncMediator(4, Block)
+	sink := newRecorder("sink")
+	m.Register("sink", sink)
+	senders := []string{"alice", "bob", "carol"}
+	handles := map[string]Mediator{}
+	for _, name := range senders {
+		r := newRecorder(name)
+		m.Register(name, r)
+		handles[name] = r.mediator
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < events; i++ {
+				if err := handles[name].SendTo("sink", fmt.Sprint(i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	m.Close()
+
+	next := map[string]int{}
+	for _, e := range sink.events() {
+		var from string
+		var i int
+		if _, err := fmt.Sscanf(e, "%s %d", &from, &i); err != nil {
+			t.Fatal(err)
+		}
+		from = from[:len(from)-1] // trailing colon
+		if i != next[from] {
+			t.Fatalf("from %s: got event %d, want %d", from, i, next[from])
+		}
+		next[from]++
+	}
+	for _, name := range senders {
+		if next[name] != events {
+			t.Errorf("from %s: received %d events, want %d", name, next[name], events)
+		}
+	}
+	if stats, _ := m.Stats("sink"); stats.Delivered != len(senders)*events {
+		t.Errorf("delivered %d, want %d", stats.Delivered, len(senders)*events)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+A chat server is the classic real-world mediator: every connection is a
+colleague and every room is a mediator, so clients never write to each
+other's connections directly.
+
+The protocol is line based:
+
+	/nick <name>        change nickname
+	/join <room>        leave the current room and join another one
+	/msg <name> <text>  private message to someone in the same room
+	/who                list the people in the current room
+	/quit               disconnect
+	<text>              say something to the current room
+
+Every client has its own outbound queue drained by its own writer goroutine,
+so a client that stops reading never blocks whoever is talking to the room.
+When its queue overflows, or a write times out, the slow client is
+disconnected.
+*/
+
+const (
+	outboxSize   = 64
+	writeTimeout = 5 * time.Second
+)
+
+// ChatServer owns the rooms and the set of nicknames in use.
+type ChatServer struct {
+	mu     sync.Mutex
+	rooms  map[string]*ConcreteMediator
+	nicks  map[string]bool
+	guests int
+
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+	wg        sync.WaitGroup // one per connection in conns
+}
+
+func NewChatServer() *ChatServer {
+	return &ChatServer{
+		rooms:     make(map[string]*ConcreteMediator),
+		nicks:     make(map[string]bool),
+		listeners: make(map[net.Listener]bool),
+		conns:     make(map[net.Conn]bool),
+	}
+}
+
+// ListenAndServe accepts connections on addr, e.g. "127.0.0.1:9000".
+func (s *ChatServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until l is closed.
+func (s *ChatServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// Close stops every listener, disconnects every client, whether it came
+// through Serve or ServeConn, and waits for their handlers to return.
+// Connections handed over afterwards are closed right away.
+func (s *ChatServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// ServeConn handles a single client until it quits or the connection is
+// closed. It works on any net.Conn, including one end of net.Pipe.
+func (s *ChatServer) ServeConn(conn net.Conn) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = true
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+
+	c := &chatClient{
+		server:  s,
+		conn:    conn,
+		nick:    s.guestNick(),
+		outbox:  make(chan string, outboxSize),
+		flushed: make(chan struct{}),
+	}
+	go c.writeLoop()
+	defer func() {
+		c.leave()
+		s.releaseNick(c.nick)
+		// Let the writer flush what is queued, such as "bye".
+		c.closeOutbox()
+		<-c.flushed
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	c.write(fmt.Sprintf("welcome %s, use /join <room> to start chatting", c.nick))
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "/quit" {
+			c.write("bye")
+			return
+		}
+		if err := c.handle(line); err != nil {
+			c.write("error: " + err.Error())
+		}
+	}
+}
+
+func (s *ChatServer) guestNick() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		s.guests++
+		nick := fmt.Sprintf("guest%d", s.guests)
+		if !s.nicks[nick] {
+			s.nicks[nick] = true
+			return nick
+		}
+	}
+}
+
+func (s *ChatServer) claimNick(nick string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nicks[nick] {
+		return fmt.Errorf("nickname %q is already in use", nick)
+	}
+	s.nicks[nick] = true
+	return nil
+}
+
+func (s *ChatServer) releaseNick(nick string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.nicks, nick)
+}
+
+// enter registers the client in the named room, creating the room on first
+// use.
+func (s *ChatServer) enter(name string, c *chatClient) (*ConcreteMediator, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[name]
+	if !ok {
+		room = NewConcreteMediator()
+		s.rooms[name] = room
+	}
+	if err := room.Register(c.nick, c); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// exit unregisters the client and forgets the room once its last member has
+// left.
+func (s *ChatServer) exit(name string, nick string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[name]
+	if !ok {
+		return
+	}
+	room.Unregister(nick)
+	if len(room.Names()) == 0 {
+		delete(s.rooms, name)
+	}
+}
+
+// rename re-registers the client under its new nickname without letting the
+// room be dropped in between.
+func (s *ChatServer) rename(name, old string, c *chatClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room := s.rooms[name]
+	room.Unregister(old)
+	return room.Register(c.nick, c)
+}
+
+// chatClient is the Colleague for a single connection.
+type chatClient struct {
+	server *ChatServer
+	conn   net.Conn
+
+	outMu   sync.Mutex
+	outbox  chan string
+	closed  bool
+	flushed chan struct{} // closed when writeLoop returns
+
+	nick     string
+	roomName string
+	room     *ConcreteMediator
+	mediator Mediator
+}
+
+func (c *chatClient) SetMediator(mediator Mediator) {
+	c.mediator = mediator
+}
+
+func (c *chatClient) Send(event string) {
+	c.mediator.Notify(event)
+}
+
+// Receive queues the already formatted event for the connection.
+func (c *chatClient) Receive(from, event string) {
+	c.write(event)
+}
+
+// write queues the line without blocking. A client whose queue is full is
+// too slow to keep up and gets disconnected.
+func (c *chatClient) write(line string) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.outbox <- line:
+	default:
+		c.closed = true
+		close(c.outbox)
+		c.conn.Close()
+	}
+}
+
+func (c *chatClient) closeOutbox() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.outbox)
+	}
+}
+
+func (c *chatClient) writeLoop() {
+	defer close(c.flushed)
+	for line := range c.outbox {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := io.WriteString(c.conn, line+"\n"); err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+func (c *chatClient) handle(line string) error {
+	if !strings.HasPrefix(line, "/") {
+		if c.mediator == nil {
+			return fmt.Errorf("join a room first")
+		}
+		c.Send(fmt.Sprintf("[%s] %s: %s", c.roomName, c.nick, line))
+		return nil
+	}
+
+	command, args, _ := strings.Cut(line, " ")
+	args = strings.TrimSpace(args)
+	switch command {
+	case "/nick":
+		return c.rename(args)
+	case "/join":
+		return c.join(args)
+	case "/msg":
+		to, text, ok := strings.Cut(args, " ")
+		if !ok || strings.TrimSpace(text) == "" {
+			return fmt.Errorf("usage: /msg <name> <text>")
+		}
+		if c.mediator == nil {
+			return fmt.Errorf("join a room first")
+		}
+		return c.mediator.SendTo(to, fmt.Sprintf("[pm] %s: %s", c.nick, strings.TrimSpace(text)))
+	case "/who":
+		if c.mediator == nil {
+			return fmt.Errorf("join a room first")
+		}
+		names := c.room.Names()
+		c.write(fmt.Sprintf("in %s: %s", c.roomName, strings.Join(names, ", ")))
+		return nil
+	default:
+		return fmt.Errorf("unknown command %s", command)
+	}
+}
+
+func (c *chatClient) rename(nick string) error {
+	if nick == "" || strings.ContainsAny(nick, " /") {
+		return fmt.Errorf("invalid nickname %q", nick)
+	}
+	if nick == c.nick {
+		c.write("you are now " + nick)
+		return nil
+	}
+	if err := c.server.claimNick(nick); err != nil {
+		return err
+	}
+	old := c.nick
+	c.server.releaseNick(old)
+	c.nick = nick
+
+	if c.mediator != nil {
+		if err := c.server.rename(c.roomName, old, c); err != nil {
+			return err
+		}
+		c.Send(fmt.Sprintf("* %s is now known as %s", old, nick))
+	}
+	c.write("you are now " + nick)
+	return nil
+}
+
+func (c *chatClient) join(name string) error {
+	if name == "" {
+		return fmt.Errorf("usage: /join <room>")
+	}
+	if name == c.roomName {
+		return fmt.Errorf("already in %s", name)
+	}
+	c.leave()
+
+	room, err := c.server.enter(name, c)
+	if err != nil {
+		return err
+	}
+	c.room = room
+	c.roomName = name
+	c.Send(fmt.Sprintf("* %s joined %s", c.nick, name))
+	c.write("joined " + name)
+	return nil
+}
+
+func (c *chatClient) leave() {
+	if c.mediator == nil {
+		return
+	}
+	c.Send(fmt.Sprintf("* %s left %s", c.nick, c.roomName))
+	c.server.exit(c.roomName, c.nick)
+	c.room = nil
+	c.mediator = nil
+	c.roomName = ""
+}
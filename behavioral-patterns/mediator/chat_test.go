@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipeClient is the client end of a net.Pipe served by a ChatServer. A
+// goroutine keeps reading so the server is never blocked by the test.
+type pipeClient struct {
+	t     *testing.T
+	conn  net.Conn
+	lines chan string
+}
+
+func dial(t *testing.T, s *ChatServer) *pipeClient {
+	t.Helper()
+	server, client := net.Pipe()
+	go s.ServeConn(server)
+
+	c := &pipeClient{t: t, conn: client, lines: make(chan string, 1000)}
+	go func() {
+		defer close(c.lines)
+		scanner := bufio.NewScanner(client)
+		for scanner.Scan() {
+			c.lines <- scanner.Text()
+		}
+	}()
+	c.next() // welcome
+	return c
+}
+
+func (c *pipeClient) send(line string) {
+	c.t.Helper()
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := fmt.Fprintln(c.conn, line); err != nil {
+		c.t.Fatalf("sending %q: %v", line, err)
+	}
+}
+
+func (c *pipeClient) next() string {
+	c.t.Helper()
+	select {
+	case line, ok := <-c.lines:
+		if !ok {
+			c.t.Fatal("connection closed")
+		}
+		return line
+	case <-time.After(2 * time.Second):
+		c.t.Fatal("timed out waiting for a line")
+	}
+	return ""
+}
+
+func (c *pipeClient) expect(want string) {
+	c.t.Helper()
+	if got := c.next(); got != want {
+		c.t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestChatCommands(t *testing.T) {
+	s := NewChatServer()
+	defer s.Close()
+	alice, bob := dial(t, s), dial(t, s)
+
+	alice.send("/nick alice")
+	alice.expect("you are now alice")
+	bob.send("/nick alice")
+	bob.expect(`error: nickname "alice" is already in use`)
+	bob.send("/nick bob")
+	bob.expect("you are now bob")
+
+	bob.send("/who")
+	bob.expect("error: join a room first")
+
+	alice.send("/join lobby")
+	alice.expect("joined lobby")
+	bob.send("/join lobby")
+	bob.expect("joined lobby")
+	alice.expect("* bob joined lobby")
+
+	// Taking your own nickname again changes nothing and tells no one.
+	alice.send("/nick alice")
+	alice.expect("you are now alice")
+
+	alice.send("hello")
+	bob.expect("[lobby] alice: hello")
+
+	alice.send("/msg bob psst")
+	bob.expect("[pm] alice: psst")
+	alice.send("/msg nobody hi")
+	alice.expect(`error: colleague "nobody" is not registered`)
+
+	bob.send("/who")
+	bob.expect("in lobby: alice, bob")
+
+	bob.send("/nick robert")
+	alice.expect("* bob is now known as robert")
+	bob.expect("you are now robert")
+	alice.send("/who")
+	alice.expect("in lobby: alice, robert")
+
+	bob.send("/quit")
+	bob.expect("bye")
+	alice.expect("* robert left lobby")
+}
+
+func TestSlowClientDoesNotStallRoom(t *testing.T) {
+	s := NewChatServer()
+	defer s.Close()
+	alice, bob := dial(t, s), dial(t, s)
+	alice.send("/join lobby")
+	alice.expect("joined lobby")
+	bob.send("/join lobby")
+	bob.expect("joined lobby")
+	alice.expect("* guest2 joined lobby")
+
+	// The silent client joins but never reads a single line.
+	server, silent := net.Pipe()
+	defer silent.Close()
+	go s.ServeConn(server)
+	silent.SetWriteDeadline(time.Now().Add(time.Second))
+	fmt.Fprintln(silent, "/join lobby")
+	alice.expect("* guest3 joined lobby")
+	bob.expect("* guest3 joined lobby")
+
+	errs := make(chan error, 1)
+	go func() {
+		for i := 0; i < 2*outboxSize; i++ {
+			alice.conn.SetWriteDeadline(time.Now().Add(time.Second))
+			if _, err := fmt.Fprintf(alice.conn, "message %d\n", i); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+
+	// The silent client overflows its queue and is disconnected somewhere
+	// in between; everyone else still gets every message, in order.
+	left := false
+	for i := 0; i < 2*outboxSize; {
+		line := bob.next()
+		if line == "* guest3 left lobby" && !left {
+			left = true
+			continue
+		}
+		if want := fmt.Sprintf("[lobby] guest1: message %d", i); line != want {
+			t.Fatalf("got %q, want %q", line, want)
+		}
+		i++
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if !left {
+		bob.expect("* guest3 left lobby")
+	}
+	bob.send("/who")
+	bob.expect("in lobby: guest1, guest2")
+}
+
+func TestCloseDisconnectsServeConnClients(t *testing.T) {
+	s := NewChatServer()
+	alice := dial(t, s)
+	alice.send("/join lobby")
+	alice.expect("joined lobby")
+
+	closed := make(chan error)
+	go func() { closed <- s.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not disconnect a client served by ServeConn")
+	}
+	for range alice.lines {
+	}
+
+	// A connection handed over after Close is closed right away.
+	server, client := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(server)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn served a connection after Close")
+	}
+}
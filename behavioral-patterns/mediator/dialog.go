@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+)
+
+/*
+The dialog from the refactoring.guru example: a customer profile form made of
+text fields, a checkbox and a submit button. The components never reference
+each other; they only tell the dialog that something happened, and the dialog
+decides what that means for the rest of the form:
+
+1. Ticking "I have a dog" reveals the dog's name field and makes it required.
+2. The submit button is enabled only while every visible required field is valid.
+
+The form can be driven headlessly through SetText, Toggle and Click, or
+interactively in a plain terminal with RunDialog.
+*/
+
+// DialogMediator is the mediator the form components talk to.
+type DialogMediator interface {
+	Notify(sender Component, event string)
+}
+
+// Component is a form element.
+type Component interface {
+	Name() string
+	Visible() bool
+	Render(w io.Writer)
+	SetDialog(dialog DialogMediator)
+}
+
+type component struct {
+	name   string
+	label  string
+	hidden bool
+	dialog DialogMediator
+}
+
+func (c *component) Name() string {
+	return c.name
+}
+
+func (c *component) Visible() bool {
+	return !c.hidden
+}
+
+func (c *component) SetDialog(dialog DialogMediator) {
+	c.dialog = dialog
+}
+
+type TextField struct {
+	component
+	value    string
+	required bool
+	validate func(value string) error
+	err      error
+}
+
+func NewTextField(name, label string, validate func(value string) error) *TextField {
+	return &TextField{component: component{name: name, label: label}, validate: validate}
+}
+
+func (f *TextField) SetText(value string) {
+	f.value = strings.TrimSpace(value)
+	f.dialog.Notify(f, "change")
+}
+
+func (f *TextField) Value() string {
+	return f.value
+}
+
+// Valid reports whether the field holds an acceptable value, remembering the
+// reason when it doesn't.
+func (f *TextField) Valid() bool {
+	f.err = nil
+	switch {
+	case f.value == "" && f.required:
+		f.err = fmt.Errorf("required")
+	case f.value != "" && f.validate != nil:
+		f.err = f.validate(f.value)
+	}
+	return f.err == nil
+}
+
+func (f *TextField) Render(w io.Writer) {
+	marker := " "
+	if f.required {
+		marker = "*"
+	}
+	fmt.Fprintf(w, "%s%-12s [%s]", marker, f.label, f.value)
+	if f.err != nil && f.value != "" {
+		fmt.Fprintf(w, "  ! %v", f.err)
+	}
+	fmt.Fprintln(w)
+}
+
+type Checkbox struct {
+	component
+	checked bool
+}
+
+func NewCheckbox(name, label string) *Checkbox {
+	return &Checkbox{component: component{name: name, label: label}}
+}
+
+func (c *Checkbox) Toggle() {
+	c.checked = !c.checked
+	c.dialog.Notify(c, "toggle")
+}
+
+func (c *Checkbox) Checked() bool {
+	return c.checked
+}
+
+func (c *Checkbox) Render(w io.Writer) {
+	mark := " "
+	if c.checked {
+		mark = "x"
+	}
+	fmt.Fprintf(w, " [%s] %s\n", mark, c.label)
+}
+
+type Button struct {
+	component
+	enabled bool
+}
+
+func NewButton(name, label string) *Button {
+	return &Button{component: component{name: name, label: label}}
+}
+
+func (b *Button) Click() {
+	b.dialog.Notify(b, "click")
+}
+
+func (b *Button) Enabled() bool {
+	return b.enabled
+}
+
+func (b *Button) Render(w io.Writer) {
+	if b.enabled {
+		fmt.Fprintf(w, " < %s >\n", b.label)
+	} else {
+		fmt.Fprintf(w, " ( %s - disabled )\n", b.label)
+	}
+}
+
+// Profile is what the dialog produces once submitted.
+type Profile struct {
+	Name    string
+	Email   string
+	DogName string
+}
+
+// ProfileDialog is the concrete mediator.
+type ProfileDialog struct {
+	name    *TextField
+	email   *TextField
+	hasDog  *Checkbox
+	dogName *TextField
+	submit  *Button
+
+	components []Component
+	submitted  *Profile
+}
+
+func NewProfileDialog() *ProfileDialog {
+	d := &ProfileDialog{
+		name:    NewTextField("name", "Name", nil),
+		email:   NewTextField("email", "Email", validEmail),
+		hasDog:  NewCheckbox("dog", "I have a dog"),
+		dogName: NewTextField("dogname", "Dog's name", nil),
+		submit:  NewButton("submit", "Submit"),
+	}
+	d.name.required = true
+	d.email.required = true
+	d.dogName.hidden = true
+
+	d.components = []Component{d.name, d.email, d.hasDog, d.dogName, d.submit}
+	for _, c := range d.components {
+		c.SetDialog(d)
+	}
+	d.update()
+	return d
+}
+
+func validEmail(value string) error {
+	if _, err := mail.ParseAddress(value); err != nil {
+		return fmt.Errorf("not a valid email address")
+	}
+	return nil
+}
+
+func (d *ProfileDialog) Notify(sender Component, event string) {
+	switch {
+	case sender == d.hasDog && event == "toggle":
+		d.dogName.hidden = !d.hasDog.Checked()
+		d.dogName.required = d.hasDog.Checked()
+	case sender == d.submit && event == "click":
+		if d.submit.Enabled() {
+			d.submitted = &Profile{Name: d.name.Value(), Email: d.email.Value()}
+			if d.hasDog.Checked() {
+				d.submitted.DogName = d.dogName.Value()
+			}
+		}
+		return
+	}
+	d.update()
+}
+
+// update re-validates the visible fields and enables submit accordingly.
+func (d *ProfileDialog) update() {
+	valid := true
+	for _, c := range d.components {
+		if field, ok := c.(*TextField); ok && field.Visible() {
+			valid = field.Valid() && valid
+		}
+	}
+	d.submit.enabled = valid
+}
+
+func (d *ProfileDialog) find(name string) (Component, error) {
+	for _, c := range d.components {
+		if c.Name() == name && c.Visible() {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no visible component %q", name)
+}
+
+func (d *ProfileDialog) SetText(name, value string) error {
+	c, err := d.find(name)
+	if err != nil {
+		return err
+	}
+	field, ok := c.(*TextField)
+	if !ok {
+		return fmt.Errorf("%q is not a text field", name)
+	}
+	field.SetText(value)
+	return nil
+}
+
+func (d *ProfileDialog) Toggle(name string) error {
+	c, err := d.find(name)
+	if err != nil {
+		return err
+	}
+	checkbox, ok := c.(*Checkbox)
+	if !ok {
+		return fmt.Errorf("%q is not a checkbox", name)
+	}
+	checkbox.Toggle()
+	return nil
+}
+
+func (d *ProfileDialog) Click(name string) error {
+	c, err := d.find(name)
+	if err != nil {
+		return err
+	}
+	button, ok := c.(*Button)
+	if !ok {
+		return fmt.Errorf("%q is not a button", name)
+	}
+	if !button.Enabled() {
+		return fmt.Errorf("%q is disabled", name)
+	}
+	button.Click()
+	return nil
+}
+
+// Submitted returns the profile once the form has been submitted.
+func (d *ProfileDialog) Submitted() (Profile, bool) {
+	if d.submitted == nil {
+		return Profile{}, false
+	}
+	return *d.submitted, true
+}
+
+func (d *ProfileDialog) Render(w io.Writer) {
+	for _, c := range d.components {
+		if c.Visible() {
+			fmt.Fprintf(w, "%-8s", c.Name())
+			c.Render(w)
+		}
+	}
+}
+
+// RunDialog drives the form from line-based input until it is submitted or
+// the input ends. Commands:
+//
+//	set <field> <text>
+//	toggle <checkbox>
+//	click <button>
+func RunDialog(d *ProfileDialog, in io.Reader, out io.Writer) (Profile, bool) {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintln(out)
+		d.Render(out)
+		if profile, ok := d.Submitted(); ok {
+			return profile, true
+		}
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			return Profile{}, false
+		}
+
+		command, args, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		var err error
+		switch command {
+		case "set":
+			name, value, _ := strings.Cut(args, " ")
+			err = d.SetText(name, value)
+		case "toggle":
+			err = d.Toggle(args)
+		case "click":
+			err = d.Click(args)
+		default:
+			err = fmt.Errorf("commands: set <field> <text>, toggle <checkbox>, click <button>")
+		}
+		if err != nil {
+			fmt.Fprintln(out, "error:", err)
+		}
+	}
+}
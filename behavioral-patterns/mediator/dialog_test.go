@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProfileDialog(t *testing.T) {
+	type step struct {
+		do         func(d *ProfileDialog) error
+		wantErr    string
+		submit     bool // submit button enabled afterwards
+		dogVisible bool
+	}
+	set := func(name, value string) func(d *ProfileDialog) error {
+		return func(d *ProfileDialog) error { return d.SetText(name, value) }
+	}
+	toggle := func(name string) func(d *ProfileDialog) error {
+		return func(d *ProfileDialog) error { return d.Toggle(name) }
+	}
+	click := func(name string) func(d *ProfileDialog) error {
+		return func(d *ProfileDialog) error { return d.Click(name) }
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		want  *Profile // submitted profile, if any
+	}{
+		{
+			name: "required fields enable submit",
+			steps: []step{
+				{do: click("submit"), wantErr: `"submit" is disabled`},
+				{do: set("name", "Ada")},
+				{do: set("email", "not an address")},
+				{do: set("email", "ada@example.com"), submit: true},
+				{do: set("name", "  "), submit: false},
+				{do: set("name", "Ada"), submit: true},
+				{do: click("submit"), submit: true},
+			},
+			want: &Profile{Name: "Ada", Email: "ada@example.com"},
+		},
+		{
+			name: "the dog checkbox reveals a required field",
+			steps: []step{
+				{do: set("dogname", "Rex"), wantErr: `no visible component "dogname"`},
+				{do: set("name", "Ada")},
+				{do: set("email", "ada@example.com"), submit: true},
+				{do: toggle("dog"), dogVisible: true},
+				{do: click("submit"), wantErr: `"submit" is disabled`, dogVisible: true},
+				{do: set("dogname", "Rex"), submit: true, dogVisible: true},
+				{do: click("submit"), submit: true, dogVisible: true},
+			},
+			want: &Profile{Name: "Ada", Email: "ada@example.com", DogName: "Rex"},
+		},
+		{
+			name: "unticking the checkbox hides the field and drops the dog",
+			steps: []step{
+				{do: set("name", "Ada")},
+				{do: set("email", "ada@example.com"), submit: true},
+				{do: toggle("dog"), dogVisible: true},
+				{do: set("dogname", "Rex"), submit: true, dogVisible: true},
+				{do: toggle("dog"), submit: true},
+				{do: click("submit"), submit: true},
+			},
+			want: &Profile{Name: "Ada", Email: "ada@example.com"},
+		},
+		{
+			name: "components are used as what they are",
+			steps: []step{
+				{do: toggle("name"), wantErr: `"name" is not a checkbox`},
+				{do: click("dog"), wantErr: `"dog" is not a button`},
+				{do: set("submit", "x"), wantErr: `"submit" is not a text field`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewProfileDialog()
+			for i, s := range tt.steps {
+				err := s.do(d)
+				gotErr := ""
+				if err != nil {
+					gotErr = err.Error()
+				}
+				if gotErr != s.wantErr {
+					t.Fatalf("step %d: error %q, want %q", i, gotErr, s.wantErr)
+				}
+				if d.submit.Enabled() != s.submit {
+					t.Fatalf("step %d: submit enabled %v, want %v", i, d.submit.Enabled(), s.submit)
+				}
+				if d.dogName.Visible() != s.dogVisible || d.dogName.required != s.dogVisible {
+					t.Fatalf("step %d: dog's name visible %v, required %v, want both %v", i, d.dogName.Visible(), d.dogName.required, s.dogVisible)
+				}
+			}
+			profile, ok := d.Submitted()
+			if ok != (tt.want != nil) || ok && profile != *tt.want {
+				t.Errorf("submitted %+v (%v), want %+v", profile, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDialog(t *testing.T) {
+	in := strings.NewReader(strings.Join([]string{
+		"set name Ada Lovelace",
+		"set email ada@example.com",
+		"toggle dog",
+		"set dogname Rex",
+		"click submit",
+	}, "\n"))
+	profile, ok := RunDialog(NewProfileDialog(), in, io.Discard)
+	want := Profile{Name: "Ada Lovelace", Email: "ada@example.com", DogName: "Rex"}
+	if !ok || profile != want {
+		t.Errorf("got %+v (%v), want %+v", profile, ok, want)
+	}
+
+	var out strings.Builder
+	if _, ok := RunDialog(NewProfileDialog(), strings.NewReader("click submit\nwave\n"), &out); ok {
+		t.Error("the form was submitted without its required fields")
+	}
+	for _, s := range []string{`error: "submit" is disabled`, "error: commands:"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("output does not contain %q:\n%s", s, out.String())
+		}
+	}
+}
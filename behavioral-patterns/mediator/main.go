@@ -1,6 +1,16 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
 
 /*
 https://refactoring.guru/design-patterns/mediator
@@ -53,92 +63,399 @@ to enforce constraints in the terminal area because the number of
 involved actors there might be overwhelming to a pilot.
 */
 // Mediator Interface
+//
+// Every colleague is handed its own Mediator when it is registered. The
+// handle is bound to the name the colleague was registered under, so a
+// colleague can only ever send as itself.
 type Mediator interface {
-	Notify(sender Colleague, event string)
+	Notify(event string)
+	Publish(topic, event string) error
+	SendTo(recipient, event string) error
+	Request(ctx context.Context, recipient string, body any) (Reply, error)
+	RequestFirst(ctx context.Context, topic string, body any) (Reply, error)
 }
 
 // Colleague Interface
 type Colleague interface {
 	SetMediator(mediator Mediator)
 	Send(event string)
-	Receive(event string)
+	Receive(from, event string)
 }
 
 // Concrete Mediator
+//
+// Colleagues are registered under a unique name and can join or leave at any
+// time. Events are routed by topic, to an explicit recipient or broadcast to
+// everyone except the sender.
 type ConcreteMediator struct {
-	colleague1 Colleague
-	colleague2 Colleague
+	mu         sync.RWMutex
+	colleagues map[string]Colleague
+	members    map[string]*member
+	topics     map[string]map[string]bool
+
+	requests uint64
+	pending  map[string]chan Reply
 }
 
-func (m *ConcreteMediator) SetColleague1(c Colleague) {
-	m.colleague1 = c
+func NewConcreteMediator() *ConcreteMediator {
+	return &ConcreteMediator{
+		colleagues: make(map[string]Colleague),
+		members:    make(map[string]*member),
+		topics:     make(map[string]map[string]bool),
+		pending:    make(map[string]chan Reply),
+	}
 }
 
-func (m *ConcreteMediator) SetColleague2(c Colleague) {
-	m.colleague2 = c
+// Register adds the colleague under name and hands it a Mediator bound to
+// that name.
+func (m *ConcreteMediator) Register(name string, c Colleague) error {
+	return m.register(name, c, m)
 }
 
-func (m *ConcreteMediator) Notify(sender Colleague, event string) {
-	if sender == m.colleague1 {
-		m.colleague2.Receive(event)
-	} else {
-		m.colleague1.Receive(event)
+// register binds the colleague's handle to r, so a mediator embedding
+// ConcreteMediator can route the colleague's events itself.
+func (m *ConcreteMediator) register(name string, c Colleague, r router) error {
+	if name == "" {
+		return fmt.Errorf("colleague name must not be empty")
+	}
+	m.mu.Lock()
+	if _, ok := m.colleagues[name]; ok {
+		m.mu.Unlock()
+		return fmt.Errorf("colleague %q already registered", name)
 	}
+	handle := &member{router: r, name: name}
+	m.colleagues[name] = c
+	m.members[name] = handle
+	m.mu.Unlock()
+
+	c.SetMediator(handle)
+	return nil
 }
 
-// Concrete Colleague 1
-type ConcreteColleague1 struct {
-	mediator Mediator
+func (m *ConcreteMediator) Unregister(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.colleagues[name]; !ok {
+		return fmt.Errorf("colleague %q is not registered", name)
+	}
+	delete(m.colleagues, name)
+	delete(m.members, name)
+	for topic, members := range m.topics {
+		delete(members, name)
+		if len(members) == 0 {
+			delete(m.topics, topic)
+		}
+	}
+	return nil
 }
 
-func (c *ConcreteColleague1) SetMediator(mediator Mediator) {
-	c.mediator = mediator
+func (m *ConcreteMediator) Subscribe(name, topic string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.colleagues[name]; !ok {
+		return fmt.Errorf("colleague %q is not registered", name)
+	}
+	if m.topics[topic] == nil {
+		m.topics[topic] = make(map[string]bool)
+	}
+	m.topics[topic][name] = true
+	return nil
+}
+
+func (m *ConcreteMediator) Unsubscribe(name, topic string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.topics[topic], name)
+	if len(m.topics[topic]) == 0 {
+		delete(m.topics, topic)
+	}
+}
+
+// Names returns the registered colleague names in alphabetical order.
+func (m *ConcreteMediator) Names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.colleagues))
+	for name := range m.colleagues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// router is the sender-aware side of a mediator. Colleagues only reach it
+// through their member handle, which supplies the sender.
+type router interface {
+	notify(from *member, event string)
+	publish(from *member, topic, event string) error
+	sendTo(from *member, recipient, event string) error
+	request(ctx context.Context, from *member, recipient string, body any) (Reply, error)
+	requestFirst(ctx context.Context, from *member, topic string, body any) (Reply, error)
+}
+
+// member is the Mediator a colleague gets on registration. Senders are
+// identified by this handle rather than by the Colleague value, since a
+// colleague that embeds *ConcreteColleague passes the embedded pointer, not
+// itself, and the name it prints may differ from the one it registered under.
+type member struct {
+	router router
+	name   string
+}
+
+func (h *member) Notify(event string) {
+	h.router.notify(h, event)
+}
+
+func (h *member) Publish(topic, event string) error {
+	return h.router.publish(h, topic, event)
+}
+
+func (h *member) SendTo(recipient, event string) error {
+	return h.router.sendTo(h, recipient, event)
+}
+
+func (h *member) Request(ctx context.Context, recipient string, body any) (Reply, error) {
+	return h.router.request(ctx, h, recipient, body)
+}
+
+func (h *member) RequestFirst(ctx context.Context, topic string, body any) (Reply, error) {
+	return h.router.requestFirst(ctx, h, topic, body)
+}
+
+// notify broadcasts the event to every colleague except the sender.
+func (m *ConcreteMediator) notify(from *member, event string) {
+	if names, err := m.broadcastRoute(from); err == nil {
+		m.deliver(from.name, names, event)
+	}
+}
+
+// publish delivers the event to every colleague subscribed to the topic,
+// except the sender.
+func (m *ConcreteMediator) publish(from *member, topic, event string) error {
+	names, err := m.topicRoute(from, topic)
+	if err != nil {
+		return err
+	}
+	m.deliver(from.name, names, event)
+	return nil
+}
+
+// sendTo delivers the event to a single colleague.
+func (m *ConcreteMediator) sendTo(from *member, recipient, event string) error {
+	names, err := m.directRoute(from, recipient)
+	if err != nil {
+		return err
+	}
+	m.deliver(from.name, names, event)
+	return nil
+}
+
+// checkSender rejects handles of colleagues that have left, even if someone
+// else has registered under the same name since. It must be called with
+// m.mu held.
+func (m *ConcreteMediator) checkSender(from *member) error {
+	if m.members[from.name] != from {
+		return fmt.Errorf("colleague %q is no longer registered", from.name)
+	}
+	return nil
+}
+
+// broadcastRoute returns everyone except the sender.
+func (m *ConcreteMediator) broadcastRoute(from *member) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkSender(from); err != nil {
+		return nil, err
+	}
+	return m.collect(from.name, func(name string) bool { return true }), nil
 }
 
-func (c *ConcreteColleague1) Send(event string) {
-	fmt.Println("Colleague 1 sends:", event)
-	c.mediator.Notify(c, event)
+// topicRoute returns the topic's subscribers except the sender.
+func (m *ConcreteMediator) topicRoute(from *member, topic string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkSender(from); err != nil {
+		return nil, err
+	}
+	members, ok := m.topics[topic]
+	if !ok {
+		return nil, fmt.Errorf("no colleague subscribed to topic %q", topic)
+	}
+	return m.collect(from.name, func(name string) bool { return members[name] }), nil
 }
 
-func (c *ConcreteColleague1) Receive(event string) {
-	fmt.Println("Colleague 1 receives:", event)
+// directRoute checks the recipient exists.
+func (m *ConcreteMediator) directRoute(from *member, recipient string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkSender(from); err != nil {
+		return nil, err
+	}
+	if _, ok := m.colleagues[recipient]; !ok {
+		return nil, fmt.Errorf("colleague %q is not registered", recipient)
+	}
+	return []string{recipient}, nil
+}
+
+// collect returns the matching names sorted so delivery order is
+// deterministic. It must be called with m.mu held.
+func (m *ConcreteMediator) collect(exclude string, match func(name string) bool) []string {
+	names := make([]string, 0, len(m.colleagues))
+	for name := range m.colleagues {
+		if name != exclude && match(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
 }
 
-// Concrete Colleague 2
-type ConcreteColleague2 struct {
+// deliver runs outside the lock so colleagues may send, join or leave from
+// inside Receive. Colleagues that left in the meantime are skipped.
+func (m *ConcreteMediator) deliver(from string, names []string, event string) {
+	for _, name := range names {
+		m.mu.RLock()
+		c, ok := m.colleagues[name]
+		m.mu.RUnlock()
+		if ok {
+			c.Receive(from, event)
+		}
+	}
+}
+
+// Concrete Colleague
+type ConcreteColleague struct {
+	name     string
 	mediator Mediator
 }
 
-func (c *ConcreteColleague2) SetMediator(mediator Mediator) {
+func NewConcreteColleague(name string) *ConcreteColleague {
+	return &ConcreteColleague{name: name}
+}
+
+func (c *ConcreteColleague) SetMediator(mediator Mediator) {
 	c.mediator = mediator
 }
 
-func (c *ConcreteColleague2) Send(event string) {
-	fmt.Println("Colleague 2 sends:", event)
-	c.mediator.Notify(c, event)
+func (c *ConcreteColleague) Send(event string) {
+	fmt.Printf("%s sends: %s\n", c.name, event)
+	c.mediator.Notify(event)
 }
 
-func (c *ConcreteColleague2) Receive(event string) {
-	fmt.Println("Colleague 2 receives:", event)
+func (c *ConcreteColleague) Publish(topic, event string) error {
+	fmt.Printf("%s publishes on %s: %s\n", c.name, topic, event)
+	return c.mediator.Publish(topic, event)
+}
+
+func (c *ConcreteColleague) SendTo(recipient, event string) error {
+	fmt.Printf("%s sends to %s: %s\n", c.name, recipient, event)
+	return c.mediator.SendTo(recipient, event)
+}
+
+func (c *ConcreteColleague) Receive(from, event string) {
+	fmt.Printf("%s receives from %s: %s\n", c.name, from, event)
 }
 
 func main() {
-	mediator := &ConcreteMediator{}
+	chatAddr := flag.String("chat", "", "run the chat server on this address, e.g. 127.0.0.1:9000")
+	dialog := flag.Bool("dialog", false, "fill in the profile dialog in the terminal")
+	flag.Parse()
 
-	colleague1 := &ConcreteColleague1{}
-	colleague2 := &ConcreteColleague2{}
+	if *chatAddr != "" {
+		fmt.Println("Chat server listening on", *chatAddr)
+		log.Fatal(NewChatServer().ListenAndServe(*chatAddr))
+	}
+	if *dialog {
+		if profile, ok := RunDialog(NewProfileDialog(), os.Stdin, os.Stdout); ok {
+			fmt.Printf("Submitted: %+v\n", profile)
+		}
+		return
+	}
+
+	mediator := NewConcreteMediator()
+
+	alice := NewConcreteColleague("alice")
+	bob := NewConcreteColleague("bob")
+	carol := NewConcreteColleague("carol")
+
+	// Registering a colleague also sets its mediator
+	mediator.Register("alice", alice)
+	mediator.Register("bob", bob)
+	mediator.Register("carol", carol)
+
+	mediator.Subscribe("bob", "billing")
+	mediator.Subscribe("carol", "billing")
+
+	// Broadcast to everyone except the sender
+	alice.Send("Hello everyone")
+
+	// Route by topic
+	alice.Publish("billing", "Invoice #42 is ready")
+
+	// Explicit recipient
+	bob.SendTo("carol", "Can you double-check #42?")
+
+	// Colleagues can leave at runtime
+	mediator.Unregister("carol")
+	if err := bob.SendTo("carol", "Are you there?"); err != nil {
+		fmt.Println("Error:", err)
+	}
+	alice.Send("Carol has left")
+
+	// Request/reply: the first pricing service to answer wins
+	fmt.Println()
+	mediator.Register("cheap", &priceService{NewConcreteColleague("cheap"), 0})
+	mediator.Register("broken", &priceService{NewConcreteColleague("broken"), -1})
+	mediator.Subscribe("cheap", "pricing")
+	mediator.Subscribe("broken", "pricing")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if price, err := AskFirst[int](ctx, alice.mediator, "pricing", "widget"); err == nil {
+		fmt.Println("alice got a quote:", price)
+	}
+	if _, err := Ask[int](ctx, alice.mediator, "broken", "widget"); err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	// Asynchronous delivery: a slow colleague no longer blocks the sender
+	fmt.Println()
+	async := NewAsyncMediator(2, DropOldest)
+	async.Register("alice", NewConcreteColleague("alice"))
+	async.Register("dave", &slowColleague{NewConcreteColleague("dave"), 10 * time.Millisecond})
+
+	sender := NewConcreteColleague("eve")
+	async.Register("eve", sender)
+	for i := 1; i <= 5; i++ {
+		sender.Send(fmt.Sprintf("update %d", i))
+	}
+	// Close waits for the mailboxes to drain, so the counters are final
+	async.Close()
+	stats, _ := async.Stats("dave")
+	fmt.Printf("dave dropped %d of 5 updates\n", stats.Dropped)
+}
 
-	// Set the mediator for both colleagues
-	colleague1.SetMediator(mediator)
-	colleague2.SetMediator(mediator)
+// priceService answers pricing requests, or fails when it has no discount.
+type priceService struct {
+	*ConcreteColleague
+	discount int
+}
 
-	// Set up the relationship in the mediator
-	mediator.SetColleague1(colleague1)
-	mediator.SetColleague2(colleague2)
+func (s *priceService) Respond(ctx context.Context, request Request) (any, error) {
+	if s.discount < 0 {
+		return nil, errors.New("price list unavailable")
+	}
+	fmt.Printf("%s quotes %v for %s (%s)\n", s.name, request.Body, request.From, request.ID)
+	return 100 - s.discount, nil
+}
 
-	// Colleague 1 sends a message
-	colleague1.Send("Hello from Colleague 1")
+// slowColleague takes its time to process every event.
+type slowColleague struct {
+	*ConcreteColleague
+	delay time.Duration
+}
 
-	// Colleague 2 sends a message
-	colleague2.Send("Hello from Colleague 2")
+func (c *slowColleague) Receive(from, event string) {
+	time.Sleep(c.delay)
+	c.ConcreteColleague.Receive(from, event)
 }
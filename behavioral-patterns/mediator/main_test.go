@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// recorder wraps ConcreteColleague the way priceService and slowColleague
+// do, and records what it receives.
+type recorder struct {
+	*ConcreteColleague
+
+	mu       sync.Mutex
+	received []string
+}
+
+func newRecorder(name string) *recorder {
+	return &recorder{ConcreteColleague: NewConcreteColleague(name)}
+}
+
+func (r *recorder) Receive(from, event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received = append(r.received, from+": "+event)
+}
+
+func (r *recorder) events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.received...)
+}
+
+func TestWrappedColleagueIsIdentifiedAsSender(t *testing.T) {
+	m := NewConcreteMediator()
+	alice, bob := newRecorder("alice"), newRecorder("bob")
+	m.Register("alice", alice)
+	m.Register("bob", bob)
+	m.Subscribe("alice", "news")
+	m.Subscribe("bob", "news")
+
+	alice.Send("hello")
+	if err := alice.Publish("news", "headline"); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.SendTo("bob", "psst"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := alice.events(); len(got) != 0 {
+		t.Errorf("alice received her own events: %v", got)
+	}
+	want := []string{"alice: hello", "alice: headline", "alice: psst"}
+	if got := bob.events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("bob received %v, want %v", got, want)
+	}
+}
+
+func TestSendToUnknownRecipient(t *testing.T) {
+	m := NewConcreteMediator()
+	alice := newRecorder("alice")
+	m.Register("alice", alice)
+	if err := alice.SendTo("nobody", "hi"); err == nil {
+		t.Fatal("expected an error for an unregistered recipient")
+	}
+}
+
+func TestSenderIsTheRegisteredName(t *testing.T) {
+	m := NewConcreteMediator()
+	// The colleague calls itself something else than the name it was
+	// registered under; only the registered name counts.
+	alias, bob := newRecorder("alias"), newRecorder("bob")
+	m.Register("alice", alias)
+	m.Register("bob", bob)
+
+	alias.Send("hello")
+	if got := alias.events(); len(got) != 0 {
+		t.Errorf("alice received her own broadcast: %v", got)
+	}
+	if got, want := bob.events(), []string{"alice: hello"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bob received %v, want %v", got, want)
+	}
+}
+
+func TestHandleOfUnregisteredColleagueIsRejected(t *testing.T) {
+	m := NewConcreteMediator()
+	old, bob := newRecorder("alice"), newRecorder("bob")
+	m.Register("alice", old)
+	m.Register("bob", bob)
+	stale := old.mediator
+
+	m.Unregister("alice")
+	m.Register("alice", newRecorder("alice"))
+
+	stale.Notify("impersonated")
+	if err := stale.SendTo("bob", "impersonated"); err == nil {
+		t.Error("a stale handle could still send")
+	}
+	if got := bob.events(); len(got) != 0 {
+		t.Errorf("bob received %v from a stale handle", got)
+	}
+}
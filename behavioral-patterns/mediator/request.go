@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+/*
+Notify, Publish and SendTo are fire-and-forget. Request and RequestFirst add
+request/reply on top of the same routing: the mediator stamps every request
+with a correlation ID, hands it to the colleagues that implement Responder and
+routes the matching reply back to the caller.
+
+Timeouts come from the context: when it is done the caller gets an error
+wrapping ctx.Err() and replies that arrive later are discarded. Errors
+returned by a responder travel back to the caller as well, and so does a
+panic in Respond, as an error wrapping ErrResponderPanicked.
+*/
+
+var (
+	ErrNoResponder       = errors.New("no responder")
+	ErrResponderPanicked = errors.New("responder panicked")
+)
+
+// Responder is implemented by colleagues that can answer requests.
+type Responder interface {
+	Respond(ctx context.Context, request Request) (any, error)
+}
+
+type Request struct {
+	ID   string
+	From string
+	Body any
+}
+
+type Reply struct {
+	ID   string
+	From string
+	Body any
+	Err  error
+}
+
+// request asks a single colleague and waits for its reply.
+func (m *ConcreteMediator) request(ctx context.Context, from *member, recipient string, body any) (Reply, error) {
+	names, err := m.directRoute(from, recipient)
+	if err != nil {
+		return Reply{}, err
+	}
+	return m.exchange(ctx, from.name, names, body)
+}
+
+// requestFirst asks every subscriber of the topic and returns the first
+// successful reply. It only fails once every responder has failed or the
+// context is done.
+func (m *ConcreteMediator) requestFirst(ctx context.Context, from *member, topic string, body any) (Reply, error) {
+	names, err := m.topicRoute(from, topic)
+	if err != nil {
+		return Reply{}, err
+	}
+	return m.exchange(ctx, from.name, names, body)
+}
+
+func (m *ConcreteMediator) exchange(ctx context.Context, from string, names []string, body any) (Reply, error) {
+	responders := make(map[string]Responder, len(names))
+	m.mu.RLock()
+	for _, name := range names {
+		if r, ok := m.colleagues[name].(Responder); ok {
+			responders[name] = r
+		}
+	}
+	m.mu.RUnlock()
+	if len(responders) == 0 {
+		return Reply{}, fmt.Errorf("%w among %v", ErrNoResponder, names)
+	}
+
+	id := fmt.Sprintf("req-%d", atomic.AddUint64(&m.requests, 1))
+	replies := make(chan Reply, len(responders))
+	m.mu.Lock()
+	m.pending[id] = replies
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.pending, id)
+		m.mu.Unlock()
+	}()
+
+	// Cancelled as soon as the caller has its answer so the remaining
+	// responders can stop working.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	request := Request{ID: id, From: from, Body: body}
+	for name, r := range responders {
+		go func(name string, r Responder) {
+			body, err := respond(ctx, r, request)
+			m.reply(Reply{ID: id, From: name, Body: body, Err: err})
+		}(name, r)
+	}
+
+	var errs []error
+	for range responders {
+		select {
+		case reply := <-replies:
+			if reply.Err == nil {
+				return reply, nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", reply.From, reply.Err))
+		case <-ctx.Done():
+			return Reply{ID: id}, fmt.Errorf("request %s: %w", id, ctx.Err())
+		}
+	}
+	return Reply{ID: id}, fmt.Errorf("request %s: %w", id, errors.Join(errs...))
+}
+
+// respond runs the responder on its own goroutine, where a panic would
+// otherwise take the whole process down.
+func respond(ctx context.Context, r Responder, request Request) (body any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			body, err = nil, fmt.Errorf("%w: %v", ErrResponderPanicked, p)
+		}
+	}()
+	return r.Respond(ctx, request)
+}
+
+// reply routes a reply to the caller waiting on its correlation ID. Replies
+// for requests that already completed or timed out are dropped.
+func (m *ConcreteMediator) reply(reply Reply) {
+	m.mu.RLock()
+	replies, ok := m.pending[reply.ID]
+	m.mu.RUnlock()
+	if !ok {
+		return
+	}
+	select {
+	case replies <- reply:
+	default:
+	}
+}
+
+// Ask sends a request to one colleague and type-checks the reply.
+func Ask[T any](ctx context.Context, m Mediator, recipient string, body any) (T, error) {
+	reply, err := m.Request(ctx, recipient, body)
+	return replyAs[T](reply, err)
+}
+
+// AskFirst sends a request to a topic and type-checks the first reply.
+func AskFirst[T any](ctx context.Context, m Mediator, topic string, body any) (T, error) {
+	reply, err := m.RequestFirst(ctx, topic, body)
+	return replyAs[T](reply, err)
+}
+
+func replyAs[T any](reply Reply, err error) (T, error) {
+	var zero T
+	if err != nil {
+		return zero, err
+	}
+	value, ok := reply.Body.(T)
+	if !ok {
+		return zero, fmt.Errorf("reply %s from %s: got %T, want %T", reply.ID, reply.From, reply.Body, zero)
+	}
+	return value, nil
+}
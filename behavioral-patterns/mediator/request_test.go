@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// responder answers after delay with body or err, or panics.
+type responder struct {
+	*recorder
+	delay  time.Duration
+	body   any
+	err    error
+	panics bool
+}
+
+func (r *responder) Respond(ctx context.Context, request Request) (any, error) {
+	select {
+	case <-time.After(r.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if r.panics {
+		panic("responder bug")
+	}
+	return r.body, r.err
+}
+
+func TestRequestFirst(t *testing.T) {
+	errDown := errors.New("price list unavailable")
+	tests := []struct {
+		name       string
+		responders map[string]*responder
+		timeout    time.Duration
+		wantFrom   string
+		wantErr    error
+		errMention []string // names the error must mention
+	}{
+		{
+			name: "the first reply wins",
+			responders: map[string]*responder{
+				"fast": {delay: 0, body: 90},
+				"slow": {delay: 200 * time.Millisecond, body: 80},
+			},
+			wantFrom: "fast",
+		},
+		{
+			name: "failures are skipped while another responder succeeds",
+			responders: map[string]*responder{
+				"down": {err: errDown},
+				"up":   {delay: 20 * time.Millisecond, body: 100},
+			},
+			wantFrom: "up",
+		},
+		{
+			name: "a panic does not hide a later success",
+			responders: map[string]*responder{
+				"buggy": {panics: true},
+				"up":    {delay: 20 * time.Millisecond, body: 100},
+			},
+			wantFrom: "up",
+		},
+		{
+			name: "every error is returned once all responders failed",
+			responders: map[string]*responder{
+				"down":  {err: errDown},
+				"buggy": {panics: true},
+			},
+			wantErr:    errDown,
+			errMention: []string{"down", "buggy", ErrResponderPanicked.Error()},
+		},
+		{
+			name: "a panic is returned as an error",
+			responders: map[string]*responder{
+				"buggy": {panics: true},
+			},
+			wantErr:    ErrResponderPanicked,
+			errMention: []string{"buggy", "responder bug"},
+		},
+		{
+			name: "the context's deadline ends the request",
+			responders: map[string]*responder{
+				"stuck": {delay: time.Hour},
+			},
+			timeout: 20 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewConcreteMediator()
+			alice := newRecorder("alice")
+			m.Register("alice", alice)
+			for name, r := range tt.responders {
+				r.recorder = newRecorder(name)
+				m.Register(name, r)
+				m.Subscribe(name, "pricing")
+			}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			reply, err := alice.mediator.RequestFirst(ctx, "pricing", "widget")
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want an error wrapping %v", err, tt.wantErr)
+				}
+				for _, s := range tt.errMention {
+					if !strings.Contains(err.Error(), s) {
+						t.Errorf("error %q does not mention %q", err, s)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.From != tt.wantFrom || reply.Body != tt.responders[tt.wantFrom].body {
+				t.Errorf("reply %+v, want %v from %s", reply, tt.responders[tt.wantFrom].body, tt.wantFrom)
+			}
+		})
+	}
+}
+
+func TestAsk(t *testing.T) {
+	m := NewConcreteMediator()
+	alice := newRecorder("alice")
+	m.Register("alice", alice)
+	m.Register("quotes", &responder{recorder: newRecorder("quotes"), body: 42})
+	m.Register("silent", newRecorder("silent"))
+
+	ctx := context.Background()
+	if price, err := Ask[int](ctx, alice.mediator, "quotes", "widget"); err != nil || price != 42 {
+		t.Errorf("Ask = %v, %v, want 42", price, err)
+	}
+	if _, err := Ask[string](ctx, alice.mediator, "quotes", "widget"); err == nil {
+		t.Error("a reply of the wrong type was accepted")
+	}
+	if _, err := Ask[int](ctx, alice.mediator, "silent", "widget"); !errors.Is(err, ErrNoResponder) {
+		t.Errorf("asking a colleague that can't respond: got %v, want %v", err, ErrNoResponder)
+	}
+	if _, err := Ask[int](ctx, alice.mediator, "nobody", "widget"); err == nil {
+		t.Error("asked an unregistered colleague")
+	}
+}
@@ -1,6 +1,16 @@
 package main
 
-import "fmt"
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"memento/sealed"
+)
 
 /*
 https://refactoring.guru/design-patterns/memento
@@ -49,11 +59,11 @@ type Originator struct {
 	state string
 }
 
-func (e *Originator) createMemento() *Memento {
-	return &Memento{state: e.state}
+func (e *Originator) createMemento() *Memento[string] {
+	return &Memento[string]{state: e.state}
 }
 
-func (e *Originator) restoreMemento(m *Memento) {
+func (e *Originator) restoreMemento(m *Memento[string]) {
 	e.state = m.getSavedState()
 }
 
@@ -65,51 +75,279 @@ func (e *Originator) getState() string {
 	return e.state
 }
 
-type Memento struct {
-	state string
+// Memento holds a snapshot of any originator's state.
+type Memento[S any] struct {
+	state S
 }
 
-func (m *Memento) getSavedState() string {
+func (m *Memento[S]) getSavedState() S {
 	return m.state
 }
 
-type Caretaker struct {
-	mementoArray []*Memento
+// originator is what the caretaker needs from the object whose history it
+// keeps.
+type originator[S any] interface {
+	createMemento() *Memento[S]
+	restoreMemento(m *Memento[S])
 }
 
-func (c *Caretaker) addMemento(m *Memento) {
-	c.mementoArray = append(c.mementoArray, m)
+var (
+	errNothingToUndo = errors.New("nothing to undo")
+	errNothingToRedo = errors.New("nothing to redo")
+)
+
+// historyLimits bounds how much the caretaker remembers. A zero value means
+// unlimited. sizeOf is required when maxBytes is set.
+type historyLimits[S any] struct {
+	maxDepth int
+	maxBytes int
+	sizeOf   func(state S) int
 }
 
-func (c *Caretaker) getMemento(index int) *Memento {
-	return c.mementoArray[index]
+// Caretaker keeps an undo and a redo stack of mementos. Once a limit is
+// exceeded the oldest undo steps are forgotten. The memory budget covers both
+// stacks, so when the undo stack is empty the redo steps furthest from the
+// current state go next.
+type Caretaker[S any] struct {
+	originator originator[S]
+	limits     historyLimits[S]
+	undoStack  []*Memento[S]
+	redoStack  []*Memento[S]
 }
 
-func main() {
+func newCaretaker[S any](o originator[S], limits historyLimits[S]) (*Caretaker[S], error) {
+	if limits.maxDepth < 0 || limits.maxBytes < 0 {
+		return nil, fmt.Errorf("history limits must not be negative")
+	}
+	if limits.maxBytes > 0 && limits.sizeOf == nil {
+		return nil, fmt.Errorf("a memory budget needs a sizeOf function")
+	}
+	return &Caretaker[S]{originator: o, limits: limits}, nil
+}
+
+// save records the originator's current state before it is edited. Any redo
+// history is invalidated since it no longer follows from the new edit.
+func (c *Caretaker[S]) save() {
+	c.undoStack = append(c.undoStack, c.originator.createMemento())
+	c.redoStack = nil
+	c.trim()
+}
 
-	caretaker := &Caretaker{
-		mementoArray: make([]*Memento, 0),
+func (c *Caretaker[S]) undo() error {
+	if len(c.undoStack) == 0 {
+		return errNothingToUndo
 	}
+	c.redoStack = append(c.redoStack, c.originator.createMemento())
+	c.originator.restoreMemento(pop(&c.undoStack))
+	c.trim()
+	return nil
+}
+
+func (c *Caretaker[S]) redo() error {
+	if len(c.redoStack) == 0 {
+		return errNothingToRedo
+	}
+	c.undoStack = append(c.undoStack, c.originator.createMemento())
+	c.originator.restoreMemento(pop(&c.redoStack))
+	c.trim()
+	return nil
+}
+
+func (c *Caretaker[S]) canUndo() bool {
+	return len(c.undoStack) > 0
+}
+
+func (c *Caretaker[S]) canRedo() bool {
+	return len(c.redoStack) > 0
+}
 
+// size is the memory used by the undo and redo stacks according to sizeOf.
+func (c *Caretaker[S]) size() int {
+	if c.limits.sizeOf == nil {
+		return 0
+	}
+	total := 0
+	for _, m := range c.undoStack {
+		total += c.limits.sizeOf(m.state)
+	}
+	for _, m := range c.redoStack {
+		total += c.limits.sizeOf(m.state)
+	}
+	return total
+}
+
+func (c *Caretaker[S]) trim() {
+	for c.limits.maxDepth > 0 && len(c.undoStack) > c.limits.maxDepth {
+		c.undoStack = c.undoStack[1:]
+	}
+	for c.limits.maxBytes > 0 && c.size() > c.limits.maxBytes {
+		switch {
+		case len(c.undoStack) > 0:
+			c.undoStack = c.undoStack[1:]
+		case len(c.redoStack) > 0:
+			c.redoStack = c.redoStack[1:]
+		default:
+			return
+		}
+	}
+}
+
+func pop[S any](stack *[]*Memento[S]) *Memento[S] {
+	m := (*stack)[len(*stack)-1]
+	*stack = (*stack)[:len(*stack)-1]
+	return m
+}
+
+func main() {
 	originator := &Originator{
 		state: "A",
 	}
 
+	caretaker, err := newCaretaker[string](originator, historyLimits[string]{maxDepth: 2})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
 	fmt.Printf("Originator Current State: %s\n", originator.getState())
-	caretaker.addMemento(originator.createMemento())
 
+	caretaker.save()
 	originator.setState("B")
 	fmt.Printf("Originator Current State: %s\n", originator.getState())
-	caretaker.addMemento(originator.createMemento())
 
+	caretaker.save()
 	originator.setState("C")
 	fmt.Printf("Originator Current State: %s\n", originator.getState())
-	caretaker.addMemento(originator.createMemento())
 
-	originator.restoreMemento(caretaker.getMemento(1))
-	fmt.Printf("Restored to State: %s\n", originator.getState())
+	caretaker.save()
+	originator.setState("D")
+	fmt.Printf("Originator Current State: %s\n", originator.getState())
+
+	caretaker.undo()
+	fmt.Printf("Undo to State: %s\n", originator.getState())
 
-	originator.restoreMemento(caretaker.getMemento(0))
-	fmt.Printf("Restored to State: %s\n", originator.getState())
+	caretaker.undo()
+	fmt.Printf("Undo to State: %s\n", originator.getState())
 
+	// Only two steps are kept, so "A" has been forgotten
+	if err := caretaker.undo(); err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	caretaker.redo()
+	fmt.Printf("Redo to State: %s\n", originator.getState())
+
+	// A new edit invalidates what is left to redo
+	caretaker.save()
+	originator.setState("E")
+	fmt.Printf("Originator Current State: %s\n", originator.getState())
+	if err := caretaker.redo(); err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	persistenceDemo()
+	sealedDemo()
+}
+
+// legacyState is how version 1 of the schema stored the originator's state.
+type legacyState struct {
+	Text string
+}
+
+// upgradeLegacyState migrates version 1 snapshots to the plain string used
+// since version 2.
+func upgradeLegacyState(payload []byte, c codec) ([]byte, error) {
+	var old legacyState
+	if err := c.decode(payload, &old); err != nil {
+		return nil, err
+	}
+	return c.encode(old.Text)
+}
+
+func persistenceDemo() {
+	dir, err := os.MkdirTemp("", "mementos")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []codec{gobCodec{}, jsonCodec{}} {
+		store, err := newFileStore[string](dir, c, 2, map[int]migration{1: upgradeLegacyState})
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+
+		originator := &Originator{state: "saved with " + c.name()}
+		if err := store.save("latest", originator.createMemento()); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+
+		// A fresh originator, as after a restart
+		restarted := &Originator{}
+		m, err := store.load("latest")
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		restarted.restoreMemento(m)
+		fmt.Printf("Restored from disk: %s\n", restarted.getState())
+
+		// A snapshot written by schema version 1 is migrated on load
+		legacy, _ := newFileStore[legacyState](dir, c, 1, nil)
+		legacy.save("legacy", &Memento[legacyState]{state: legacyState{Text: "from version 1"}})
+		if m, err := store.load("legacy"); err == nil {
+			restarted.restoreMemento(m)
+			fmt.Printf("Restored after migration: %s\n", restarted.getState())
+		}
+	}
+
+	// Tampering with the payload is caught by the checksum
+	path := filepath.Join(dir, "latest.json")
+	data, _ := os.ReadFile(path)
+	var env snapshotEnvelope
+	json.Unmarshal(data, &env)
+	env.Payload = []byte(`"forged"`)
+	data, _ = json.Marshal(env)
+	os.WriteFile(path, data, 0o644)
+
+	store, _ := newFileStore[string](dir, jsonCodec{}, 2, map[int]migration{1: upgradeLegacyState})
+	if _, err := store.load("latest"); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func sealedDemo() {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	originator, err := sealed.New(secret, sealed.Encrypted)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	originator.SetState("secret draft")
+	m := originator.Save("before publishing")
+	originator.SetState("published")
+
+	// The caretaker only stores bytes it can neither read nor forge
+	data, _ := originator.Marshal(m)
+
+	restarted, _ := sealed.New(secret, sealed.Encrypted)
+	if restored, err := restarted.Unmarshal(data); err == nil {
+		restarted.Restore(restored)
+		fmt.Printf("Restored %q: %s\n", restored.Label(), restarted.State())
+	}
+
+	tampered := bytes.Replace(data, []byte(`"label":"before publishing"`), []byte(`"label":"approved"`), 1)
+	if _, err := restarted.Unmarshal(tampered); err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	other, _ := sealed.New(make([]byte, 32), sealed.Encrypted)
+	if err := other.Restore(m); err != nil {
+		fmt.Println("Error:", err)
+	}
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCaretakerMemoryBudgetCoversRedo(t *testing.T) {
+	o := &Originator{state: "aaaa"}
+	c, err := newCaretaker[string](o, historyLimits[string]{
+		maxBytes: 8,
+		sizeOf:   func(s string) int { return len(s) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, next := range []string{"bbbb", "cccc", "dddd"} {
+		c.save()
+		o.setState(next)
+		if size := c.size(); size > 8 {
+			t.Fatalf("after save: size %d exceeds the budget", size)
+		}
+	}
+
+	// Undoing moves states onto the redo stack; the budget still holds.
+	for c.canUndo() {
+		if err := c.undo(); err != nil {
+			t.Fatal(err)
+		}
+		if size := c.size(); size > 8 {
+			t.Fatalf("after undo: size %d exceeds the budget", size)
+		}
+	}
+	if got := o.getState(); got != "bbbb" {
+		t.Errorf("oldest reachable state = %q, want %q", got, "bbbb")
+	}
+
+	// The redo steps closest to the current state are the ones kept.
+	if err := c.redo(); err != nil {
+		t.Fatal(err)
+	}
+	if got := o.getState(); got != "cccc" {
+		t.Errorf("after redo: state = %q, want %q", got, "cccc")
+	}
+}
+
+func TestCaretakerDepthLimit(t *testing.T) {
+	o := &Originator{state: "A"}
+	c, _ := newCaretaker[string](o, historyLimits[string]{maxDepth: 2})
+	for _, next := range []string{"B", "C", "D"} {
+		c.save()
+		o.setState(next)
+	}
+	c.undo()
+	c.undo()
+	if got := o.getState(); got != "B" {
+		t.Errorf("state = %q, want %q", got, "B")
+	}
+	if err := c.undo(); !errors.Is(err, errNothingToUndo) {
+		t.Errorf("undo past the limit: got %v, want %v", err, errNothingToUndo)
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Package sealed keeps an originator's state behind a package boundary.
+
+The Memento and Originator in the example's main package share a package with
+their caretaker, so nothing stops the caretaker from reading a memento's state
+or building one of its own. Here the caretaker only ever holds a *Memento,
+whose fields it cannot see, and goes through the Originator to save, restore
+and serialize it:
+
+ 1. A memento can only be restored by the originator that issued it. The
+    issuer is derived from the originator's key, so the same originator still
+    accepts its mementos after a restart. Every memento also carries a MAC over
+    its issuer, metadata and state, so one that was not sealed by the
+    originator, the zero value included, is rejected.
+ 2. Serialized mementos are either signed (HMAC-SHA256, state readable but
+    tamper-proof) or encrypted (AES-256-GCM, state unreadable and tamper-proof).
+    Anything that fails verification is rejected on restore.
+*/
+package sealed
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrForeign  = errors.New("memento was issued by another originator")
+	ErrTampered = errors.New("memento failed authentication")
+)
+
+// Protection selects how serialized mementos are protected.
+type Protection string
+
+const (
+	Signed    Protection = "signed"
+	Encrypted Protection = "encrypted"
+)
+
+// Memento is an opaque snapshot of an Originator's state. Only its metadata
+// is visible outside the package.
+type Memento struct {
+	issuer  string
+	label   string
+	created time.Time
+	state   string
+	mac     []byte
+}
+
+func (m *Memento) Label() string {
+	return m.label
+}
+
+func (m *Memento) CreatedAt() time.Time {
+	return m.created
+}
+
+type Originator struct {
+	state      string
+	issuer     string
+	macKey     []byte
+	aead       cipher.AEAD
+	protection Protection
+}
+
+// New returns an originator whose identity and keys are derived from a
+// 32-byte secret.
+func New(secret []byte, p Protection) (*Originator, error) {
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("secret must be 32 bytes, got %d", len(secret))
+	}
+	if p != Signed && p != Encrypted {
+		return nil, fmt.Errorf("unknown protection %q", p)
+	}
+	block, err := aes.NewCipher(derive(secret, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Originator{
+		issuer:     hex.EncodeToString(derive(secret, "issuer")[:16]),
+		macKey:     derive(secret, "authentication"),
+		aead:       aead,
+		protection: p,
+	}, nil
+}
+
+func derive(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (o *Originator) SetState(state string) {
+	o.state = state
+}
+
+func (o *Originator) State() string {
+	return o.state
+}
+
+// Save returns a memento of the current state.
+func (o *Originator) Save(label string) *Memento {
+	return o.seal(&Memento{issuer: o.issuer, label: label, created: time.Now(), state: o.state})
+}
+
+// Restore rolls the state back to m, which must have been issued by this
+// originator.
+func (o *Originator) Restore(m *Memento) error {
+	if err := o.open(m); err != nil {
+		return err
+	}
+	o.state = m.state
+	return nil
+}
+
+func (o *Originator) seal(m *Memento) *Memento {
+	m.mac = o.mementoMAC(m)
+	return m
+}
+
+// open checks that m is a memento this originator sealed.
+func (o *Originator) open(m *Memento) error {
+	if m == nil || m.issuer != o.issuer {
+		return ErrForeign
+	}
+	if !hmac.Equal(m.mac, o.mementoMAC(m)) {
+		return ErrTampered
+	}
+	return nil
+}
+
+func (o *Originator) mementoMAC(m *Memento) []byte {
+	mac := hmac.New(sha256.New, o.macKey)
+	fmt.Fprintf(mac, "memento|%s|%q|%d|", m.issuer, m.label, m.created.UnixNano())
+	mac.Write([]byte(m.state))
+	return mac.Sum(nil)
+}
+
+// envelope is the serialized form of a memento.
+type envelope struct {
+	Protection Protection `json:"protection"`
+	Issuer     string     `json:"issuer"`
+	Label      string     `json:"label"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	Nonce      []byte     `json:"nonce,omitempty"`
+	Payload    []byte     `json:"payload"`
+	MAC        []byte     `json:"mac,omitempty"`
+}
+
+// header is the metadata both protections authenticate along with the state.
+func (e *envelope) header() []byte {
+	return []byte(fmt.Sprintf("%s|%s|%q|%d", e.Protection, e.Issuer, e.Label, e.CreatedAt.UnixNano()))
+}
+
+// Marshal serializes m so a caretaker can store it without being able to
+// forge it, nor read it when the originator encrypts.
+func (o *Originator) Marshal(m *Memento) ([]byte, error) {
+	if err := o.open(m); err != nil {
+		return nil, err
+	}
+
+	env := envelope{
+		Protection: o.protection,
+		Issuer:     m.issuer,
+		Label:      m.label,
+		CreatedAt:  m.created,
+	}
+	if o.protection == Encrypted {
+		env.Nonce = make([]byte, o.aead.NonceSize())
+		if _, err := rand.Read(env.Nonce); err != nil {
+			return nil, err
+		}
+		env.Payload = o.aead.Seal(nil, env.Nonce, []byte(m.state), env.header())
+	} else {
+		env.Payload = []byte(m.state)
+		env.MAC = o.sign(&env)
+	}
+	return json.Marshal(env)
+}
+
+// Unmarshal verifies serialized data and returns the memento it holds.
+func (o *Originator) Unmarshal(data []byte) (*Memento, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTampered, err)
+	}
+	if env.Issuer != o.issuer {
+		return nil, ErrForeign
+	}
+
+	var state []byte
+	switch env.Protection {
+	case Encrypted:
+		var err error
+		if state, err = o.aead.Open(nil, env.Nonce, env.Payload, env.header()); err != nil {
+			return nil, ErrTampered
+		}
+	case Signed:
+		if !hmac.Equal(env.MAC, o.sign(&env)) {
+			return nil, ErrTampered
+		}
+		state = env.Payload
+	default:
+		return nil, ErrTampered
+	}
+
+	return o.seal(&Memento{issuer: env.Issuer, label: env.Label, created: env.CreatedAt, state: string(state)}), nil
+}
+
+func (o *Originator) sign(env *envelope) []byte {
+	mac := hmac.New(sha256.New, o.macKey)
+	mac.Write(env.header())
+	mac.Write([]byte{0})
+	mac.Write(env.Payload)
+	return mac.Sum(nil)
+}
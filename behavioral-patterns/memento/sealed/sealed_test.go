@@ -0,0 +1,118 @@
+package sealed_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"memento/sealed"
+)
+
+func newTestOriginator(t *testing.T, secret byte, p sealed.Protection) *sealed.Originator {
+	t.Helper()
+	o, err := sealed.New(bytes.Repeat([]byte{secret}, 32), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestRestoreRejectsMementosItDidNotSeal(t *testing.T) {
+	o := newTestOriginator(t, 0, sealed.Signed)
+	o.SetState("draft")
+
+	tests := []struct {
+		name    string
+		memento *sealed.Memento
+		want    error
+	}{
+		{"nil", nil, sealed.ErrForeign},
+		{"zero value", &sealed.Memento{}, sealed.ErrForeign},
+		{"issued by another originator", newTestOriginator(t, 1, sealed.Signed).Save("draft"), sealed.ErrForeign},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := o.Restore(tt.memento); !errors.Is(err, tt.want) {
+				t.Errorf("Restore: got %v, want %v", err, tt.want)
+			}
+			if _, err := o.Marshal(tt.memento); !errors.Is(err, tt.want) {
+				t.Errorf("Marshal: got %v, want %v", err, tt.want)
+			}
+			if got := o.State(); got != "draft" {
+				t.Errorf("state = %q after a rejected restore, want %q", got, "draft")
+			}
+		})
+	}
+}
+
+func TestUnmarshalRejectsTamperedData(t *testing.T) {
+	for _, p := range []sealed.Protection{sealed.Signed, sealed.Encrypted} {
+		o := newTestOriginator(t, 0, p)
+		o.SetState("draft")
+		data, err := o.Marshal(o.Save("before publishing"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name string
+			data []byte
+			want error
+		}{
+			{"relabelled", bytes.Replace(data, []byte(`"before publishing"`), []byte(`"approved"`), 1), sealed.ErrTampered},
+			{"protection downgraded", bytes.Replace(data, []byte(`"`+p+`"`), []byte(`"none"`), 1), sealed.ErrTampered},
+			{"truncated", data[:len(data)/2], sealed.ErrTampered},
+		}
+		for _, tt := range tests {
+			t.Run(string(p)+"/"+tt.name, func(t *testing.T) {
+				if _, err := o.Unmarshal(tt.data); !errors.Is(err, tt.want) {
+					t.Errorf("got %v, want %v", err, tt.want)
+				}
+			})
+		}
+
+		if _, err := newTestOriginator(t, 1, p).Unmarshal(data); !errors.Is(err, sealed.ErrForeign) {
+			t.Errorf("%s: another originator unmarshaled: got %v, want %v", p, err, sealed.ErrForeign)
+		}
+	}
+}
+
+func TestEncryptedMementosHideTheState(t *testing.T) {
+	o := newTestOriginator(t, 0, sealed.Encrypted)
+	o.SetState("secret draft")
+	data, err := o.Marshal(o.Save("draft"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret draft")) {
+		t.Errorf("serialized memento exposes the state: %s", data)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, p := range []sealed.Protection{sealed.Signed, sealed.Encrypted} {
+		o := newTestOriginator(t, 0, p)
+		o.SetState("draft")
+		m := o.Save("before publishing")
+		o.SetState("published")
+
+		data, err := o.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restarted := newTestOriginator(t, 0, p)
+		restored, err := restarted.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+		if err := restarted.Restore(restored); err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+		if got := restarted.State(); got != "draft" || restored.Label() != "before publishing" {
+			t.Errorf("%s: restored %q from %q", p, got, restored.Label())
+		}
+		if !restored.CreatedAt().Equal(m.CreatedAt()) {
+			t.Errorf("%s: created at %v, want %v", p, restored.CreatedAt(), m.CreatedAt())
+		}
+	}
+}
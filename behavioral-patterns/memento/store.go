@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Mementos normally live only as long as the caretaker holding them. A memento
+store lets them outlive the process: the originator's state is encoded, wrapped
+in an envelope that records the schema version and a SHA-256 checksum of the
+payload, and written to disk. Loading verifies the checksum before anything is
+decoded and runs the registered migrations when the snapshot was written by an
+older schema version.
+*/
+
+var (
+	errCorruptSnapshot = errors.New("snapshot checksum mismatch")
+	errUnknownVersion  = errors.New("unsupported snapshot version")
+)
+
+type mementoStore[S any] interface {
+	save(key string, m *Memento[S]) error
+	load(key string) (*Memento[S], error)
+}
+
+// codec turns values into bytes and back.
+type codec interface {
+	name() string
+	encode(v any) ([]byte, error)
+	decode(data []byte, v any) error
+}
+
+type gobCodec struct{}
+
+func (gobCodec) name() string {
+	return "gob"
+}
+
+func (gobCodec) encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) decode(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) name() string {
+	return "json"
+}
+
+func (jsonCodec) encode(v any) ([]byte, error) {
+	return json.MarshalIndent(v, "", "  ")
+}
+
+func (jsonCodec) decode(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// snapshotEnvelope is what ends up on disk.
+type snapshotEnvelope struct {
+	Version  int
+	Codec    string
+	Checksum string
+	Payload  []byte
+}
+
+// migration rewrites a payload written by one schema version into the
+// format of the next one.
+type migration func(payload []byte, c codec) ([]byte, error)
+
+type fileStore[S any] struct {
+	dir        string
+	codec      codec
+	version    int
+	migrations map[int]migration
+}
+
+// newFileStore stores snapshots in dir using the given codec. version is the
+// schema version of S; migrations[v] upgrades a version v payload to v+1.
+func newFileStore[S any](dir string, c codec, version int, migrations map[int]migration) (*fileStore[S], error) {
+	if version < 1 {
+		return nil, fmt.Errorf("schema version must be at least 1")
+	}
+	for v := 1; v < version; v++ {
+		if migrations[v] == nil {
+			return nil, fmt.Errorf("missing migration from version %d to %d", v, v+1)
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileStore[S]{dir: dir, codec: c, version: version, migrations: migrations}, nil
+}
+
+func (s *fileStore[S]) save(key string, m *Memento[S]) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	payload, err := s.codec.encode(m.state)
+	if err != nil {
+		return fmt.Errorf("encoding snapshot %q: %w", key, err)
+	}
+	data, err := s.codec.encode(snapshotEnvelope{
+		Version:  s.version,
+		Codec:    s.codec.name(),
+		Checksum: checksum(payload),
+		Payload:  payload,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding snapshot %q: %w", key, err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a half
+	// written snapshot behind.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *fileStore[S]) load(key string) (*Memento[S], error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var env snapshotEnvelope
+	if err := s.codec.decode(data, &env); err != nil {
+		return nil, fmt.Errorf("decoding snapshot %q: %w", key, err)
+	}
+	if env.Codec != s.codec.name() {
+		return nil, fmt.Errorf("snapshot %q was written with %s, not %s", key, env.Codec, s.codec.name())
+	}
+	if checksum(env.Payload) != env.Checksum {
+		return nil, fmt.Errorf("%w: %q", errCorruptSnapshot, key)
+	}
+	if env.Version < 1 || env.Version > s.version {
+		return nil, fmt.Errorf("%w: %q has version %d, expected at most %d", errUnknownVersion, key, env.Version, s.version)
+	}
+
+	payload := env.Payload
+	for v := env.Version; v < s.version; v++ {
+		if payload, err = s.migrations[v](payload, s.codec); err != nil {
+			return nil, fmt.Errorf("migrating snapshot %q from version %d: %w", key, v, err)
+		}
+	}
+
+	var state S
+	if err := s.codec.decode(payload, &state); err != nil {
+		return nil, fmt.Errorf("decoding snapshot %q: %w", key, err)
+	}
+	return &Memento[S]{state: state}, nil
+}
+
+func (s *fileStore[S]) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid snapshot key %q", key)
+	}
+	return filepath.Join(s.dir, key+"."+s.codec.name()), nil
+}
+
+func checksum(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var codecs = []codec{gobCodec{}, jsonCodec{}}
+
+type document struct {
+	Title string
+	Tags  []string
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	for _, c := range codecs {
+		t.Run(c.name(), func(t *testing.T) {
+			store, err := newFileStore[document](t.TempDir(), c, 1, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			saved := document{Title: "draft", Tags: []string{"a", "b"}}
+			if err := store.save("doc", &Memento[document]{state: saved}); err != nil {
+				t.Fatal(err)
+			}
+			m, err := store.load("doc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := m.getSavedState()
+			if got.Title != saved.Title || len(got.Tags) != 2 || got.Tags[1] != "b" {
+				t.Errorf("loaded %+v, want %+v", got, saved)
+			}
+		})
+	}
+}
+
+// rewrite changes the envelope of a saved snapshot on disk.
+func rewrite(t *testing.T, path string, c codec, change func(env *snapshotEnvelope)) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env snapshotEnvelope
+	if err := c.decode(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	change(&env)
+	if data, err = c.encode(env); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStoreRejectsBadSnapshots(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(env *snapshotEnvelope)
+		wantErr error
+	}{
+		{
+			name:    "tampered payload",
+			change:  func(env *snapshotEnvelope) { env.Payload = append(env.Payload, ' ') },
+			wantErr: errCorruptSnapshot,
+		},
+		{
+			name:    "tampered checksum",
+			change:  func(env *snapshotEnvelope) { env.Checksum = checksum([]byte("other")) },
+			wantErr: errCorruptSnapshot,
+		},
+		{
+			name:    "newer version",
+			change:  func(env *snapshotEnvelope) { env.Version = 3 },
+			wantErr: errUnknownVersion,
+		},
+		{
+			name:    "no version",
+			change:  func(env *snapshotEnvelope) { env.Version = 0 },
+			wantErr: errUnknownVersion,
+		},
+	}
+	for _, c := range codecs {
+		for _, tt := range tests {
+			t.Run(c.name()+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				store, err := newFileStore[string](dir, c, 2, map[int]migration{1: upgradeLegacyState})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := store.save("doc", &Memento[string]{state: "draft"}); err != nil {
+					t.Fatal(err)
+				}
+				rewrite(t, filepath.Join(dir, "doc."+c.name()), c, tt.change)
+				if _, err := store.load("doc"); !errors.Is(err, tt.wantErr) {
+					t.Errorf("got %v, want %v", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestFileStoreMigratesOlderVersions(t *testing.T) {
+	// Version 3 adds tags to the plain string of version 2.
+	toDocument := func(payload []byte, c codec) ([]byte, error) {
+		var title string
+		if err := c.decode(payload, &title); err != nil {
+			return nil, err
+		}
+		return c.encode(document{Title: title, Tags: []string{"migrated"}})
+	}
+	migrations := map[int]migration{1: upgradeLegacyState, 2: toDocument}
+
+	for _, c := range codecs {
+		t.Run(c.name(), func(t *testing.T) {
+			dir := t.TempDir()
+			v1, _ := newFileStore[legacyState](dir, c, 1, nil)
+			if err := v1.save("old", &Memento[legacyState]{state: legacyState{Text: "from version 1"}}); err != nil {
+				t.Fatal(err)
+			}
+			v2, _ := newFileStore[string](dir, c, 2, migrations)
+			if err := v2.save("newer", &Memento[string]{state: "from version 2"}); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := newFileStore[document](dir, c, 3, migrations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, title := range map[string]string{"old": "from version 1", "newer": "from version 2"} {
+				m, err := store.load(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := m.getSavedState(); got.Title != title || len(got.Tags) != 1 || got.Tags[0] != "migrated" {
+					t.Errorf("%s migrated to %+v, want title %q", key, got, title)
+				}
+			}
+		})
+	}
+}
+
+func TestFileStoreSetup(t *testing.T) {
+	if _, err := newFileStore[string](t.TempDir(), jsonCodec{}, 3, map[int]migration{1: upgradeLegacyState}); err == nil {
+		t.Error("a store with a missing migration was created")
+	}
+	dir := t.TempDir()
+	store, _ := newFileStore[string](dir, jsonCodec{}, 1, nil)
+	for _, key := range []string{"", ".", "..", "a/b", `a\b`} {
+		if err := store.save(key, &Memento[string]{state: "x"}); err == nil {
+			t.Errorf("saved under the invalid key %q", key)
+		}
+	}
+	store.save("doc", &Memento[string]{state: "x"})
+	os.Rename(filepath.Join(dir, "doc.json"), filepath.Join(dir, "doc.gob"))
+	gobStore, _ := newFileStore[string](dir, gobCodec{}, 1, nil)
+	if _, err := gobStore.load("doc"); err == nil {
+		t.Error("loaded a JSON snapshot with the gob codec")
+	}
+}
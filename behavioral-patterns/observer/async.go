@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+notify calls every listener inline, so one slow customer delays all the
+others. subscribeAsync wraps a listener in a buffered queue drained by its own
+goroutine: notify only enqueues and returns.
+
+Listeners that can fail implement fallibleListener; a failed delivery (or a
+panic) is retried with exponential backoff before the event is counted as
+failed. A listener that already retries on its own returns an error wrapping
+errGaveUp, which is counted as failed without retrying the whole event. Close on the EventManager stops accepting events and flushes every
+queue before returning.
+*/
+
+var (
+	errQueueFull = errors.New("subscriber queue is full")
+	errGaveUp    = errors.New("listener gave up after its own retries")
+)
+
+type overflowPolicy int
+
+const (
+	blockWhenFull overflowPolicy = iota
+	dropOldest
+	dropNewest
+)
+
+// fallibleListener is implemented by listeners whose delivery can fail.
+type fallibleListener interface {
+	Listener
+	tryUpdate(event Event) error
+}
+
+type asyncOptions struct {
+	queueSize  int
+	overflow   overflowPolicy
+	maxRetries int
+	backoff    time.Duration // delay before the first retry, doubled each time
+	maxBackoff time.Duration
+	clock      clock // times the backoff, realClock when nil
+}
+
+type deliveryMetrics struct {
+	Queued    int
+	Delivered int
+	Retried   int
+	Failed    int
+	Dropped   int
+}
+
+// asyncListener is itself a Listener: update enqueues the event for the
+// wrapped listener.
+type asyncListener struct {
+	listener Listener
+	options  asyncOptions
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	queue    []Event
+	closed   bool
+	metrics  deliveryMetrics
+	done     chan struct{}
+}
+
+func newAsyncListener(listener Listener, options asyncOptions) *asyncListener {
+	if options.queueSize <= 0 {
+		options.queueSize = 16
+	}
+	if options.backoff <= 0 {
+		options.backoff = 10 * time.Millisecond
+	}
+	if options.maxBackoff < options.backoff {
+		options.maxBackoff = 100 * options.backoff
+	}
+	if options.clock == nil {
+		options.clock = realClock{}
+	}
+	a := &asyncListener{
+		listener: listener,
+		options:  options,
+		done:     make(chan struct{}),
+	}
+	a.notEmpty = sync.NewCond(&a.mu)
+	a.notFull = sync.NewCond(&a.mu)
+	go a.run()
+	return a
+}
+
+func (a *asyncListener) update(event Event) {
+	a.enqueue(event)
+}
+
+func (a *asyncListener) enqueue(event Event) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for !a.closed && len(a.queue) >= a.options.queueSize {
+		switch a.options.overflow {
+		case dropOldest:
+			a.queue = a.queue[1:]
+			a.metrics.Dropped++
+		case dropNewest:
+			a.metrics.Dropped++
+			return errQueueFull
+		default:
+			a.notFull.Wait()
+		}
+	}
+	if a.closed {
+		a.metrics.Dropped++
+		return errors.New("subscriber is closed")
+	}
+
+	a.queue = append(a.queue, event)
+	a.notEmpty.Signal()
+	return nil
+}
+
+func (a *asyncListener) run() {
+	defer close(a.done)
+	for {
+		a.mu.Lock()
+		for len(a.queue) == 0 && !a.closed {
+			a.notEmpty.Wait()
+		}
+		if len(a.queue) == 0 {
+			a.mu.Unlock()
+			return
+		}
+		event := a.queue[0]
+		a.queue = a.queue[1:]
+		a.notFull.Signal()
+		a.mu.Unlock()
+
+		a.deliverWithRetry(event)
+	}
+}
+
+func (a *asyncListener) deliverWithRetry(event Event) {
+	delay := a.options.backoff
+	for attempt := 0; ; attempt++ {
+		err := deliver(a.listener, event)
+		if err == nil {
+			a.count(func(m *deliveryMetrics) { m.Delivered++ })
+			return
+		}
+		if attempt >= a.options.maxRetries || errors.Is(err, errGaveUp) {
+			a.count(func(m *deliveryMetrics) { m.Failed++ })
+			return
+		}
+
+		a.count(func(m *deliveryMetrics) { m.Retried++ })
+		a.sleep(delay)
+		delay *= 2
+		if delay > a.options.maxBackoff {
+			delay = a.options.maxBackoff
+		}
+	}
+}
+
+func (a *asyncListener) sleep(d time.Duration) {
+	woken := make(chan struct{})
+	a.options.clock.afterFunc(d, func() { close(woken) })
+	<-woken
+}
+
+func (a *asyncListener) count(update func(m *deliveryMetrics)) {
+	a.mu.Lock()
+	update(&a.metrics)
+	a.mu.Unlock()
+}
+
+func (a *asyncListener) stats() deliveryMetrics {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	metrics := a.metrics
+	metrics.Queued = len(a.queue)
+	return metrics
+}
+
+// close stops accepting events and waits until the queue is flushed.
+func (a *asyncListener) close() {
+	a.mu.Lock()
+	a.closed = true
+	a.notEmpty.Broadcast()
+	a.notFull.Broadcast()
+	a.mu.Unlock()
+	<-a.done
+}
+
+// subscribeAsync subscribes the listener behind its own queue and goroutine.
+// The returned asyncListener reports delivery metrics; cancel the handle to
+// remove the subscription. If subscribing fails the queue is closed again.
+func (e *EventManager) subscribeAsync(pattern EventType, listener Listener, options asyncOptions) (*asyncListener, *subscriptionHandle, error) {
+	a := newAsyncListener(listener, options)
+	e.mu.Lock()
+	e.async = append(e.async, a)
+	e.mu.Unlock()
+
+	handle, err := e.subscribe(pattern, a)
+	if err != nil {
+		e.mu.Lock()
+		e.async = slices.DeleteFunc(e.async, func(other *asyncListener) bool { return other == a })
+		e.mu.Unlock()
+		a.close()
+		return nil, nil, err
+	}
+	return a, handle, nil
+}
+
+// Close flushes the queues of all asynchronous subscribers. Events notified
+// afterwards only reach synchronous listeners.
+func (e *EventManager) Close() {
+	e.mu.Lock()
+	async := e.async
+	e.async = nil
+	e.mu.Unlock()
+
+	for _, a := range async {
+		a.close()
+	}
+}
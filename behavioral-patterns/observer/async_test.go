@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscribeAsyncInvalidPattern(t *testing.T) {
+	manager := &EventManager{}
+	a, handle, err := manager.subscribeAsync("order..created", &collector{}, asyncOptions{})
+	if err == nil {
+		t.Fatal("subscribed to an invalid pattern")
+	}
+	if a != nil || handle != nil {
+		t.Errorf("got a listener %v and handle %v along with the error", a, handle)
+	}
+	if len(manager.async) != 0 {
+		t.Errorf("%d queues left behind", len(manager.async))
+	}
+}
+
+func TestSubscribeAsyncHandle(t *testing.T) {
+	manager := &EventManager{}
+	var received collector
+	a, handle, err := manager.subscribeAsync("order.*", &received, asyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.notify(newOrder, NewOrder{ID: "A-1"})
+	if !handle.cancel() {
+		t.Fatal("handle was not active")
+	}
+	manager.notify(newOrder, NewOrder{ID: "A-2"})
+	manager.Close()
+
+	if events := received.received(); len(events) != 1 || events[0].Payload.(NewOrder).ID != "A-1" {
+		t.Errorf("received %v, want only A-1", events)
+	}
+	if stats := a.stats(); stats.Delivered != 1 {
+		t.Errorf("stats = %+v, want 1 delivered", stats)
+	}
+}
+
+// gatedCollector holds the first event in update until release is closed,
+// and signals entered when it arrives.
+type gatedCollector struct {
+	collector
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newGatedCollector() *gatedCollector {
+	return &gatedCollector{entered: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (g *gatedCollector) update(event Event) {
+	g.once.Do(func() {
+		close(g.entered)
+		<-g.release
+	})
+	g.collector.update(event)
+}
+
+func order(id string) Event {
+	return Event{Type: newOrder, Payload: NewOrder{ID: id}}
+}
+
+func orderIDs(events []Event) []string {
+	var ids []string
+	for _, e := range events {
+		ids = append(ids, e.Payload.(NewOrder).ID)
+	}
+	return ids
+}
+
+func TestAsyncOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   overflowPolicy
+		rejected error
+		want     []string
+	}{
+		{"drop oldest", dropOldest, nil, []string{"A-1", "A-3", "A-4"}},
+		{"drop newest", dropNewest, errQueueFull, []string{"A-1", "A-2", "A-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener := newGatedCollector()
+			a := newAsyncListener(listener, asyncOptions{queueSize: 2, overflow: tt.policy})
+			a.enqueue(order("A-1"))
+			<-listener.entered
+			a.enqueue(order("A-2"))
+			a.enqueue(order("A-3"))
+			if err := a.enqueue(order("A-4")); !errors.Is(err, tt.rejected) {
+				t.Errorf("enqueue on a full queue: got %v, want %v", err, tt.rejected)
+			}
+			if stats := a.stats(); stats != (deliveryMetrics{Queued: 2, Dropped: 1}) {
+				t.Errorf("stats while blocked = %+v", stats)
+			}
+
+			close(listener.release)
+			a.close()
+			if got := orderIDs(listener.received()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("received %v, want %v", got, tt.want)
+			}
+			if stats := a.stats(); stats != (deliveryMetrics{Delivered: 3, Dropped: 1}) {
+				t.Errorf("stats = %+v", stats)
+			}
+		})
+	}
+}
+
+func TestAsyncOverflowBlocks(t *testing.T) {
+	listener := newGatedCollector()
+	a := newAsyncListener(listener, asyncOptions{queueSize: 1, overflow: blockWhenFull})
+	a.enqueue(order("A-1"))
+	<-listener.entered
+	a.enqueue(order("A-2"))
+
+	enqueued := make(chan error)
+	go func() { enqueued <- a.enqueue(order("A-3")) }()
+	select {
+	case err := <-enqueued:
+		t.Fatalf("enqueue on a full queue returned %v instead of blocking", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(listener.release)
+	if err := <-enqueued; err != nil {
+		t.Fatal(err)
+	}
+	a.close()
+	if got, want := orderIDs(listener.received()), []string{"A-1", "A-2", "A-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if stats := a.stats(); stats != (deliveryMetrics{Delivered: 3}) {
+		t.Errorf("stats = %+v", stats)
+	}
+	if err := a.enqueue(order("A-4")); err == nil {
+		t.Error("enqueued after close")
+	}
+	if stats := a.stats(); stats.Dropped != 1 {
+		t.Errorf("an event enqueued after close was not counted as dropped: %+v", stats)
+	}
+}
+
+// failingListener fails its first deliveries as scripted: "error" returns
+// an error, "panic" panics.
+type failingListener struct {
+	collector
+	mu     sync.Mutex
+	script []string
+}
+
+func (f *failingListener) tryUpdate(event Event) error {
+	f.mu.Lock()
+	var outcome string
+	if len(f.script) > 0 {
+		outcome, f.script = f.script[0], f.script[1:]
+	}
+	f.mu.Unlock()
+	switch outcome {
+	case "panic":
+		panic("warehouse crashed")
+	case "error":
+		return errors.New("warehouse unavailable")
+	}
+	f.update(event)
+	return nil
+}
+
+// nextTimer waits for the listener to start its backoff timer and returns
+// the delay it was started with.
+func nextTimer(t *testing.T, c *manualClock) time.Duration {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		for _, timer := range c.timers {
+			if !timer.stopped {
+				c.mu.Unlock()
+				return timer.at.Sub(c.current)
+			}
+		}
+		c.mu.Unlock()
+	}
+	t.Fatal("no backoff timer was started")
+	return 0
+}
+
+func TestAsyncRetries(t *testing.T) {
+	clock := newManualClock(time.Unix(0, 0))
+	// A-1 fails every attempt, A-2 panics once and then goes through.
+	listener := &failingListener{script: []string{"error", "error", "error", "error", "panic"}}
+	a := newAsyncListener(listener, asyncOptions{
+		maxRetries: 3,
+		backoff:    10 * time.Millisecond,
+		maxBackoff: 30 * time.Millisecond,
+		clock:      clock,
+	})
+	a.enqueue(order("A-1"))
+	a.enqueue(order("A-2"))
+
+	// The delays double up to maxBackoff; a panic counts as a failed
+	// attempt and starts over from the initial backoff.
+	var delays []time.Duration
+	for range 4 {
+		d := nextTimer(t, clock)
+		delays = append(delays, d)
+		clock.advance(d)
+	}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 10 * time.Millisecond}
+	if !reflect.DeepEqual(delays, want) {
+		t.Errorf("backoff delays %v, want %v", delays, want)
+	}
+
+	a.close()
+	if got, want := orderIDs(listener.received()), []string{"A-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if stats := a.stats(); stats != (deliveryMetrics{Delivered: 1, Retried: 4, Failed: 1}) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Listeners that subscribe after notify has run never see past events. With an
+event log attached, every notified event is first appended to a file and
+numbered with an offset. A subscriber can then start from the beginning of
+the log, from a given offset, from now, or from the offset it committed last
+time, which lets it resume where it left off after a restart.
+
+Payloads are stored as JSON, so their types have to be registered with
+registerPayload to be decoded again on replay. Events without a payload or
+with an unnamed or unregistered payload type cannot be logged; notify still
+delivers them to the live subscribers and reports that they were not logged.
+
+A crash in the middle of an append can leave a partial last line. That event
+was never acknowledged, so openEventLog truncates it away.
+*/
+
+var payloadTypes = map[string]reflect.Type{}
+
+func registerPayload[P any]() {
+	t := reflect.TypeOf((*P)(nil)).Elem()
+	payloadTypes[t.Name()] = t
+}
+
+func init() {
+	registerPayload[NewProduct]()
+	registerPayload[NewOrder]()
+}
+
+type logRecord struct {
+	Offset      int64           `json:"offset"`
+	Time        time.Time       `json:"time"`
+	Type        EventType       `json:"type"`
+	PayloadType string          `json:"payloadType"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// eventLog is an append-only file with one JSON record per line.
+type eventLog struct {
+	mu   sync.Mutex
+	file *os.File
+	next int64
+}
+
+func openEventLog(path string) (*eventLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l := &eventLog{file: file}
+	if err := l.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// recover finds the next offset and drops a torn last record, one without
+// its trailing newline. A corrupt record before the end is still an error.
+func (l *eventLog) recover() error {
+	reader := bufio.NewReader(l.file)
+	var size int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				return l.file.Truncate(size)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var record logRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("corrupt event log %s at byte %d: %w", l.file.Name(), size, err)
+		}
+		l.next = record.Offset + 1
+		size += int64(len(line))
+	}
+}
+
+func (l *eventLog) append(event Event) (int64, error) {
+	if event.Payload == nil {
+		return 0, fmt.Errorf("%s event has no payload to log", event.Type)
+	}
+	t := reflect.TypeOf(event.Payload)
+	if t.Name() == "" || payloadTypes[t.Name()] != t {
+		return 0, fmt.Errorf("payload type %T is not registered", event.Payload)
+	}
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return 0, fmt.Errorf("encoding %s payload: %w", event.Type, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	record := logRecord{Offset: l.next, Time: time.Now(), Type: event.Type, PayloadType: t.Name(), Payload: payload}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	if err := l.file.Sync(); err != nil {
+		return 0, err
+	}
+	l.next++
+	return record.Offset, nil
+}
+
+// end is the offset the next appended event will get.
+func (l *eventLog) end() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next
+}
+
+// read calls fn for every record at or after the given offset.
+func (l *eventLog) read(from int64, fn func(record logRecord) error) error {
+	file, err := os.Open(l.file.Name())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record logRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("corrupt event log %s: %w", l.file.Name(), err)
+		}
+		if record.Offset < from {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (l *eventLog) Close() error {
+	return l.file.Close()
+}
+
+func (r logRecord) event() (Event, error) {
+	t, ok := payloadTypes[r.PayloadType]
+	if !ok {
+		return Event{}, fmt.Errorf("payload type %s is not registered", r.PayloadType)
+	}
+	payload := reflect.New(t)
+	if err := json.Unmarshal(r.Payload, payload.Interface()); err != nil {
+		return Event{}, err
+	}
+	return Event{Type: r.Type, Payload: payload.Elem().Interface(), Offset: r.Offset}, nil
+}
+
+// offsetStore remembers, per named subscriber, the offset of the next event
+// it has not processed yet.
+type offsetStore struct {
+	dir string
+}
+
+func newOffsetStore(dir string) (*offsetStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &offsetStore{dir: dir}, nil
+}
+
+func (s *offsetStore) path(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid subscriber name %q", name)
+	}
+	return filepath.Join(s.dir, name+".offset"), nil
+}
+
+func (s *offsetStore) committed(name string) (int64, bool, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return 0, false, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return offset, err == nil, err
+}
+
+func (s *offsetStore) commit(name string, offset int64) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+type startKind int
+
+const (
+	startBeginning startKind = iota
+	startOffset
+	startNow
+	startCommitted
+)
+
+type startPosition struct {
+	kind   startKind
+	offset int64
+}
+
+var (
+	fromBeginning = startPosition{kind: startBeginning}
+	fromNow       = startPosition{kind: startNow}
+	// fromCommitted resumes after the last committed offset, or from the
+	// beginning for a subscriber that never committed.
+	fromCommitted = startPosition{kind: startCommitted}
+)
+
+func fromOffset(offset int64) startPosition {
+	return startPosition{kind: startOffset, offset: offset}
+}
+
+// durableListener commits the offset of every event its listener handled.
+// An event the listener failed on is not committed, so it is replayed on the
+// next start from the committed offset.
+type durableListener struct {
+	name     string
+	listener Listener
+	offsets  *offsetStore
+}
+
+func (d *durableListener) update(event Event) {
+	d.tryUpdate(event)
+}
+
+func (d *durableListener) tryUpdate(event Event) error {
+	if err := deliver(d.listener, event); err != nil {
+		return err
+	}
+	if event.Offset < 0 {
+		return nil
+	}
+	if err := d.offsets.commit(d.name, event.Offset+1); err != nil {
+		return fmt.Errorf("committing offset %d for %s: %w", event.Offset+1, d.name, err)
+	}
+	return nil
+}
+
+// useLog makes the manager append every notified event to the log before
+// delivering it. offsets keeps the position of named subscribers.
+func (e *EventManager) useLog(log *eventLog, offsets *offsetStore) {
+	e.publishMu.Lock()
+	defer e.publishMu.Unlock()
+	e.log = log
+	e.offsets = offsets
+}
+
+// subscribeFrom replays the logged events matching the pattern from the
+// given position and then keeps the listener subscribed to live events. The
+// name identifies the subscriber's committed offset across restarts.
+func (e *EventManager) subscribeFrom(name string, pattern EventType, listener Listener, start startPosition) (*subscriptionHandle, error) {
+	if err := validatePattern(pattern); err != nil {
+		return nil, err
+	}
+	// Hold off publishers so no event is missed or delivered twice between
+	// the end of the replay and the live subscription.
+	e.publishMu.Lock()
+	defer e.publishMu.Unlock()
+	if e.log == nil {
+		return nil, fmt.Errorf("no event log attached")
+	}
+	if _, err := e.offsets.path(name); err != nil {
+		return nil, err
+	}
+
+	var from int64
+	switch start.kind {
+	case startBeginning:
+		from = 0
+	case startOffset:
+		from = start.offset
+	case startNow:
+		from = e.log.end()
+	case startCommitted:
+		offset, ok, err := e.offsets.committed(name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			from = offset
+		}
+	}
+
+	durable := &durableListener{name: name, listener: listener, offsets: e.offsets}
+	err := e.log.read(from, func(record logRecord) error {
+		if !matchPattern(pattern, record.Type) {
+			return nil
+		}
+		event, err := record.event()
+		if err != nil {
+			return err
+		}
+		return deliver(durable, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e.subscribe(pattern, durable)
+}
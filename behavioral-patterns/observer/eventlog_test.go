@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// collector records the events it receives.
+type collector struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (c *collector) update(event Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, event)
+}
+
+func (c *collector) received() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event(nil), c.events...)
+}
+
+func newLoggedManager(t *testing.T, dir string) (*EventManager, *eventLog) {
+	t.Helper()
+	log, err := openEventLog(filepath.Join(dir, "events.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.Close() })
+	offsets, err := newOffsetStore(filepath.Join(dir, "offsets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := &EventManager{}
+	manager.useLog(log, offsets)
+	return manager, log
+}
+
+func TestNotifyDeliversUnloggablePayloads(t *testing.T) {
+	type unregistered struct{ N int }
+	manager, log := newLoggedManager(t, t.TempDir())
+	var live collector
+	manager.subscribe(newProduct, &live)
+
+	payloads := []any{nil, struct{ N int }{1}, []string{"a"}, unregistered{1}}
+	for _, payload := range payloads {
+		if err := manager.notify(newProduct, payload); err == nil {
+			t.Errorf("notify(%#v) did not report the event was not logged", payload)
+		}
+	}
+	if err := manager.notify(newProduct, NewProduct{Name: "Gopher mug"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.end(); got != 1 {
+		t.Errorf("log end = %d, want 1", got)
+	}
+
+	events := live.received()
+	if len(events) != len(payloads)+1 {
+		t.Fatalf("live subscriber received %d events, want %d", len(events), len(payloads)+1)
+	}
+	for i, e := range events[:len(payloads)] {
+		if e.Offset != -1 {
+			t.Errorf("unlogged event %d has offset %d", i, e.Offset)
+		}
+	}
+	if events[len(payloads)].Offset != 0 {
+		t.Errorf("logged event has offset %d, want 0", events[len(payloads)].Offset)
+	}
+}
+
+func TestSubscribeFromValidatesPatternBeforeReplay(t *testing.T) {
+	manager, _ := newLoggedManager(t, t.TempDir())
+	manager.notify(newOrder, NewOrder{ID: "A-1"})
+
+	var replay collector
+	if _, err := manager.subscribeFrom("bob", "order..created", &replay, fromBeginning); err == nil {
+		t.Fatal("subscribed with an invalid pattern")
+	}
+	if got := replay.received(); len(got) != 0 {
+		t.Errorf("replayed %d events before rejecting the pattern", len(got))
+	}
+	if _, ok, _ := manager.offsets.committed("bob"); ok {
+		t.Error("an offset was committed for a rejected subscription")
+	}
+}
+
+func TestDurableListenerReportsCommitErrors(t *testing.T) {
+	dir := t.TempDir()
+	manager, _ := newLoggedManager(t, dir)
+	var received collector
+	if _, err := manager.subscribeFrom("bob", "order.*", &received, fromNow); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in the way of the offset file makes the commit fail.
+	blocker := filepath.Join(dir, "offsets", "bob.offset")
+	if err := os.MkdirAll(filepath.Join(blocker, "in the way"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := manager.notify(newOrder, NewOrder{ID: "A-1"})
+	if err == nil || !strings.Contains(err.Error(), "committing offset 1 for bob") {
+		t.Errorf("notify returned %v, want the commit error", err)
+	}
+	if len(received.received()) != 1 {
+		t.Error("the listener did not get the event")
+	}
+
+	// Replaying stops at the first commit error as well.
+	if _, err := manager.subscribeFrom("bob", "order.*", &collector{}, fromBeginning); err == nil {
+		t.Error("replay ignored the commit error")
+	}
+}
+
+func TestOpenEventLogDropsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.log")
+	manager, log := newLoggedManager(t, dir)
+	manager.notify(newProduct, NewProduct{Name: "Gopher plush"})
+	manager.notify(newProduct, NewProduct{Name: "Gopher mug"})
+	log.Close()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"offset":2,"time":"2024-`)
+	file.Close()
+
+	manager, log = newLoggedManager(t, dir)
+	if got := log.end(); got != 2 {
+		t.Fatalf("log end = %d after a torn record, want 2", got)
+	}
+	if err := manager.notify(newProduct, NewProduct{Name: "Gopher socks"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var replay collector
+	if _, err := manager.subscribeFrom("bob", "product.*", &replay, fromBeginning); err != nil {
+		t.Fatal(err)
+	}
+	events := replay.received()
+	if len(events) != 3 {
+		t.Fatalf("replayed %d events, want 3", len(events))
+	}
+	for i, e := range events {
+		if e.Offset != int64(i) {
+			t.Errorf("event %d has offset %d", i, e.Offset)
+		}
+	}
+	if got := events[2].Payload.(NewProduct).Name; got != "Gopher socks" {
+		t.Errorf("last event is %q", got)
+	}
+}
+
+func TestOpenEventLogRejectsCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	os.WriteFile(path, []byte("garbage\n{\"offset\":1}\n"), 0o644)
+	if _, err := openEventLog(path); err == nil {
+		t.Fatal("opened a log with a corrupt record before the end")
+	}
+}
+
+func TestSubscriberNameMustBeAFileName(t *testing.T) {
+	dir := t.TempDir()
+	manager, _ := newLoggedManager(t, dir)
+	manager.notify(newProduct, NewProduct{Name: "Gopher plush"})
+
+	for _, name := range []string{"", ".", "..", "../escape", "a/b", `a\b`} {
+		if _, err := manager.subscribeFrom(name, "product.*", &collector{}, fromCommitted); err == nil {
+			t.Errorf("subscribeFrom(%q) succeeded", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.offset")); !os.IsNotExist(err) {
+		t.Errorf("an offset was written outside the offset store: %v", err)
+	}
+
+	if _, err := manager.subscribeFrom("bob", "product.*", &collector{}, fromCommitted); err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok, err := manager.offsets.committed("bob"); err != nil || !ok || offset != 1 {
+		t.Errorf("committed(bob) = %d, %v, %v, want 1, true, nil", offset, ok, err)
+	}
+}
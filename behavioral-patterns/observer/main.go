@@ -1,6 +1,17 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
 
 /*
 https://refactoring.guru/design-patterns/observer
@@ -34,55 +45,413 @@ to and removing them from that list.
 
 type EventType string
 
+// Event types are dotted names. Subscriptions may use "*" to match exactly
+// one segment and "#" to match any number of segments, see topics.go.
 const (
-	newProduct EventType = "newProduct"
-	newOrder   EventType = "newOrder"
+	newProduct EventType = "product.created"
+	newOrder   EventType = "order.created"
+	orderPaid  EventType = "order.paid"
 )
 
+// Event payloads
+type NewProduct struct {
+	Name  string
+	Price float64
+}
+
+type NewOrder struct {
+	ID    string
+	Total float64
+	Paid  bool
+}
+
+// ProductDigest batches the products announced within a time window.
+type ProductDigest struct {
+	Products []NewProduct
+}
+
+type Event struct {
+	Type    EventType
+	Payload any
+	Offset  int64 // position in the event log, -1 when not logged
+}
+
+type subscription struct {
+	id        uint64
+	pattern   EventType
+	listener  Listener
+	filter    filter
+	priority  int
+	remaining int // deliveries left, 0 means unlimited
+	ctx       context.Context
+	stop      chan struct{} // closed when the subscription is removed
+}
+
 // The base publisher class includes subscription management
-// code and notification methods.
+// code and notification methods. It is safe to use from
+// multiple goroutines.
 type EventManager struct {
-	listeners map[EventType][]Listener
+	mu            sync.RWMutex
+	subscriptions []subscription // ordered by priority, see subscriptions.go
+	nextID        uint64
+	async         []*asyncListener
+
+	// publishMu orders logging against replays, see eventlog.go.
+	publishMu sync.Mutex
+	log       *eventLog
+	offsets   *offsetStore
 }
 
-func (e *EventManager) subscribe(eventType EventType, listener Listener) {
-	if e.listeners == nil {
-		e.listeners = make(map[EventType][]Listener)
+func (e *EventManager) subscribe(pattern EventType, listener Listener) (*subscriptionHandle, error) {
+	return e.subscribeWith(pattern, listener, subscribeOptions{})
+}
+
+// unsubscribe removes every subscription of the listener to the pattern.
+// Prefer cancelling the handle returned by subscribe: it also works for
+// listeners that can't be compared.
+func (e *EventManager) unsubscribe(pattern EventType, listener Listener) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	kept := e.subscriptions[:0]
+	for _, s := range e.subscriptions {
+		if s.pattern != pattern || !sameListener(s.listener, listener) {
+			kept = append(kept, s)
+		} else {
+			s.release()
+		}
+	}
+	clear(e.subscriptions[len(kept):])
+	e.subscriptions = kept
+}
+
+// notify delivers the payload to every listener whose subscription matches
+// the event, after appending it to the event log if there is one. A listener
+// or filter that panics is reported in the returned error and does not
+// prevent the others from being notified.
+func (e *EventManager) notify(eventType EventType, payload any) error {
+	event, candidates, logErr := e.publish(Event{Type: eventType, Payload: payload, Offset: -1})
+	recipients, failed := e.claim(event, candidates)
+	if logErr != nil {
+		failed = append([]error{logErr}, failed...)
 	}
-	e.listeners[eventType] = append(e.listeners[eventType], listener)
+	for _, listener := range recipients {
+		if err := deliver(listener, event); err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return errors.Join(failed...)
 }
 
-func (e *EventManager) unsubscribe(eventType EventType, listener Listener) {
-	for i, l := range e.listeners[eventType] {
-		if l == listener {
-			e.listeners[eventType] = append(e.listeners[eventType][:i], e.listeners[eventType][i+1:]...)
+// publish appends the event to the log and picks the subscriptions it may
+// go to. Both happen under publishMu, so a replay either finds the event in
+// the log or the live subscription among the candidates. An event that can't
+// be logged keeps offset -1 and still goes to the live subscribers.
+func (e *EventManager) publish(event Event) (Event, []subscription, error) {
+	e.publishMu.Lock()
+	defer e.publishMu.Unlock()
+	var err error
+	if e.log != nil {
+		var offset int64
+		if offset, err = e.log.append(event); err != nil {
+			err = fmt.Errorf("%s event was not logged: %w", event.Type, err)
+		} else {
+			event.Offset = offset
 		}
 	}
+	return event, e.candidates(event), err
 }
 
-func (e *EventManager) notify(eventType EventType, message string) {
-	for _, listener := range e.listeners[eventType] {
-		listener.update(message)
+// deliver hands the event to the listener and reports a panic, or the error
+// of a fallibleListener.
+func deliver(listener Listener, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("listener %T panicked on %s: %v", listener, event.Type, r)
+		}
+	}()
+	if fallible, ok := listener.(fallibleListener); ok {
+		return fallible.tryUpdate(event)
 	}
+	listener.update(event)
+	return nil
 }
 
 type Listener interface {
-	update(message string)
+	update(event Event)
+}
+
+// typedListener adapts a function that handles one payload type into a
+// Listener. Events carrying other payloads are ignored.
+type typedListener[P any] struct {
+	handle func(payload P)
+}
+
+func listen[P any](handle func(payload P)) Listener {
+	return &typedListener[P]{handle: handle}
+}
+
+func (l *typedListener[P]) update(event Event) {
+	if payload, ok := event.Payload.(P); ok {
+		l.handle(payload)
+	}
+}
+
+// topic ties an event type to its payload type, so publishing and
+// subscribing through it is checked by the compiler. The manager itself
+// carries payloads of several types side by side, and wildcard patterns,
+// the event log and webhooks see them all, which is why Event.Payload stays
+// an any and the typing happens at the edges.
+type topic[P any] EventType
+
+func (t topic[P]) publish(e *EventManager, payload P) error {
+	return e.notify(EventType(t), payload)
+}
+
+func (t topic[P]) subscribe(e *EventManager, handle func(payload P)) (*subscriptionHandle, error) {
+	return e.subscribe(EventType(t), listen(handle))
 }
 
 type Customer struct {
 	name string
 }
 
-func (c *Customer) update(message string) {
-	fmt.Printf("Customer %s received message: %s\n", c.name, message)
+func (c *Customer) update(event Event) {
+	switch payload := event.Payload.(type) {
+	case NewProduct:
+		fmt.Printf("Customer %s received message: new product %s for $%.2f\n", c.name, payload.Name, payload.Price)
+	case NewOrder:
+		fmt.Printf("Customer %s received message: %s for order %s, total $%.2f\n", c.name, event.Type, payload.ID, payload.Total)
+	case ProductDigest:
+		fmt.Printf("Customer %s received message: %d new products\n", c.name, len(payload.Products))
+		for _, product := range payload.Products {
+			fmt.Printf("  - %s for $%.2f\n", product.Name, product.Price)
+		}
+	default:
+		fmt.Printf("Customer %s received message: %v\n", c.name, payload)
+	}
 }
 
 func main() {
 	eventManager := &EventManager{}
 	customer1 := &Customer{"Alice"}
+	orders := topic[NewOrder](newOrder)
 	eventManager.subscribe(newProduct, customer1)
-	eventManager.notify(newProduct, "New product available!")
+	orders.subscribe(eventManager, func(order NewOrder) {
+		if order.Total > 1000 {
+			panic("fraud check unavailable")
+		}
+		fmt.Printf("Billing charged order %s: $%.2f\n", order.ID, order.Total)
+	})
+	eventManager.subscribe(newOrder, customer1)
+
+	eventManager.notify(newProduct, NewProduct{Name: "Gopher plush", Price: 19.99})
+	orders.publish(eventManager, NewOrder{ID: "A-1", Total: 19.99})
+
+	// A panicking listener doesn't stop Alice from being notified
+	if err := orders.publish(eventManager, NewOrder{ID: "A-2", Total: 4999}); err != nil {
+		fmt.Println("Error:", err)
+	}
+
 	eventManager.unsubscribe(newProduct, customer1)
-	eventManager.notify(newProduct, "New product available!")
+	eventManager.notify(newProduct, NewProduct{Name: "Gopher mug", Price: 9.99})
+
+	// Subscribing and notifying from many goroutines at once is safe
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			customer := &Customer{fmt.Sprintf("Customer%d", i)}
+			eventManager.subscribe(newProduct, customer)
+			eventManager.unsubscribe(newProduct, customer)
+		}(i)
+	}
+	wg.Wait()
+
+	// Marketing hears about every order event, billing only about paid ones
+	marketing := &Customer{"Marketing"}
+	billing := &Customer{"Billing"}
+	eventManager.subscribe("order.*", marketing)
+	eventManager.subscribeWhere("order.*", billing, where(func(order NewOrder) bool { return order.Paid }))
+
+	paid := NewOrder{ID: "A-4", Total: 25, Paid: true}
+	for _, listener := range eventManager.recipients(Event{Type: orderPaid, Payload: paid}) {
+		fmt.Printf("%s would receive %s\n", listener.(*Customer).name, orderPaid)
+	}
+	eventManager.notify(newOrder, NewOrder{ID: "A-4", Total: 25})
+	eventManager.notify(orderPaid, paid)
+	eventManager.unsubscribe("order.*", marketing)
+	eventManager.unsubscribe("order.*", billing)
+
+	// Handles, one-shot, context-bound and prioritized subscriptions
+	handlesDemo()
+
+	// Products announced within 5 seconds reach customers as one digest
+	digestDemo()
+
+	// Late subscribers catch up from the event log
+	if err := eventLogDemo(); err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	// Events forwarded to signed webhooks
+	webhookDemo()
+
+	// Asynchronous delivery with retries
+	warehouse := &flakyWarehouse{failures: 2}
+	queued, _, err := eventManager.subscribeAsync(newOrder, warehouse, asyncOptions{
+		queueSize:  8,
+		overflow:   blockWhenFull,
+		maxRetries: 3,
+		backoff:    5 * time.Millisecond,
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	eventManager.notify(newOrder, NewOrder{ID: "A-3", Total: 42})
+	eventManager.Close()
+	fmt.Printf("Warehouse delivery metrics: %+v\n", queued.stats())
+}
+
+// flakyWarehouse fails its first deliveries, as if its API were down.
+type flakyWarehouse struct {
+	failures int
+}
+
+func (w *flakyWarehouse) update(event Event) {
+	w.tryUpdate(event)
+}
+
+func (w *flakyWarehouse) tryUpdate(event Event) error {
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("warehouse unavailable")
+	}
+	fmt.Printf("Warehouse reserved stock for %+v\n", event.Payload)
+	return nil
+}
+
+func eventLogDemo() error {
+	dir, err := os.MkdirTemp("", "events")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	start := func() (*EventManager, *eventLog, error) {
+		log, err := openEventLog(filepath.Join(dir, "events.log"))
+		if err != nil {
+			return nil, nil, err
+		}
+		offsets, err := newOffsetStore(filepath.Join(dir, "offsets"))
+		if err != nil {
+			return nil, nil, err
+		}
+		manager := &EventManager{}
+		manager.useLog(log, offsets)
+		return manager, log, nil
+	}
+
+	manager, log, err := start()
+	if err != nil {
+		return err
+	}
+	manager.notify(newProduct, NewProduct{Name: "Gopher plush", Price: 19.99})
+	manager.notify(newProduct, NewProduct{Name: "Gopher mug", Price: 9.99})
+
+	// Bob subscribes after both products were announced
+	if _, err := manager.subscribeFrom("bob", "product.*", &Customer{"Bob"}, fromBeginning); err != nil {
+		return err
+	}
+	log.Close()
+
+	// After a restart Bob only gets what he hasn't seen yet
+	manager, log, err = start()
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+	manager.notify(newProduct, NewProduct{Name: "Gopher socks", Price: 4.99})
+	_, err = manager.subscribeFrom("bob", "product.*", &Customer{"Bob"}, fromCommitted)
+	return err
+}
+
+func webhookDemo() {
+	const secret = "s3cr3t"
+	crm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !verifySignature([]byte(secret), body, r.Header.Get(signatureHeader)) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		fmt.Printf("CRM received %s: %s\n", r.Header.Get("X-Event-Type"), body)
+	}))
+	defer crm.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	webhooks := newWebhookListener(webhookOptions{maxAttempts: 2, backoff: time.Millisecond, disableAfter: 2})
+	webhooks.registerEndpoint(crm.URL, secret)
+	webhooks.registerEndpoint(broken.URL, "other secret")
+
+	// Retries back off on the webhook's own goroutine, not inside notify
+	eventManager := &EventManager{}
+	eventManager.subscribeAsync("order.#", webhooks, asyncOptions{})
+	eventManager.notify(newOrder, NewOrder{ID: "W-1", Total: 10})
+	eventManager.notify(orderPaid, NewOrder{ID: "W-1", Total: 10, Paid: true})
+	eventManager.Close()
+
+	fmt.Printf("Webhook attempts: %d, disabled endpoints: %d\n", len(webhooks.deliveryAttempts()), len(webhooks.disabledEndpoints()))
+}
+
+func handlesDemo() {
+	manager := &EventManager{}
+	carol := &Customer{"Carol"}
+
+	// The same listener twice: each handle cancels only its own subscription
+	first, _ := manager.subscribe(newProduct, carol)
+	manager.subscribe(newProduct, carol)
+	first.cancel()
+
+	manager.subscribeOnce(newProduct, &Customer{"Once"})
+	manager.subscribeWith(newProduct, &Customer{"VIP"}, subscribeOptions{priority: 10})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	manager.subscribeWith(newProduct, &Customer{"Session"}, subscribeOptions{ctx: ctx})
+
+	manager.notify(newProduct, NewProduct{Name: "Gopher cap", Price: 14.99})
+
+	cancel()
+	manager.notify(newProduct, NewProduct{Name: "Gopher scarf", Price: 24.99})
+}
+
+func digestDemo() {
+	manager := &EventManager{}
+	clock := newManualClock(time.Now())
+
+	toDigest := func(batch Event) Event {
+		var digest ProductDigest
+		for _, event := range batch.Payload.([]Event) {
+			digest.Products = append(digest.Products, event.Payload.(NewProduct))
+		}
+		return Event{Type: "product.digest", Payload: digest, Offset: -1}
+	}
+	for _, name := range []string{"Dave", "Erin"} {
+		manager.subscribe(newProduct, pipe(&Customer{name},
+			distinctUntilChanged(func(e Event) any { return e.Payload }),
+			bufferTime(5*time.Second, clock),
+			mapEvents(toDigest),
+		))
+	}
+
+	manager.notify(newProduct, NewProduct{Name: "Gopher pin", Price: 2.99})
+	manager.notify(newProduct, NewProduct{Name: "Gopher pin", Price: 2.99}) // duplicate, dropped
+	clock.advance(3 * time.Second)
+	manager.notify(newProduct, NewProduct{Name: "Gopher hoodie", Price: 39.99})
+	clock.advance(2 * time.Second) // first window closes
+	manager.notify(newProduct, NewProduct{Name: "Gopher poster", Price: 7.99})
+	clock.advance(5 * time.Second)
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentPublishAndSubscribe(t *testing.T) {
+	manager := &EventManager{}
+	var steady collector
+	manager.subscribe(newOrder, &steady)
+
+	const publishers, events = 4, 100
+	var wg sync.WaitGroup
+	for p := range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range events {
+				manager.notify(newOrder, NewOrder{ID: fmt.Sprintf("P%d-%d", p, i)})
+			}
+		}()
+	}
+	for range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range events {
+				var c collector
+				handle, err := manager.subscribeWhere("order.*", &c, func(Event) bool { return true })
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				manager.recipients(Event{Type: newOrder})
+				handle.cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(steady.received()); got != publishers*events {
+		t.Errorf("steady subscriber received %d events, want %d", got, publishers*events)
+	}
+	if got := len(manager.subscriptions); got != 1 {
+		t.Errorf("%d subscriptions left, want 1", got)
+	}
+}
+
+func TestListenerPanicIsIsolated(t *testing.T) {
+	manager := &EventManager{}
+	var before, after collector
+	manager.subscribe(newOrder, &before)
+	manager.subscribe(newOrder, listen(func(NewOrder) { panic("listener bug") }))
+	manager.subscribe(newOrder, &after)
+
+	err := manager.notify(newOrder, NewOrder{ID: "A-1"})
+	if err == nil || !strings.Contains(err.Error(), "listener bug") {
+		t.Errorf("notify returned %v, want the panic reported", err)
+	}
+	if len(before.received()) != 1 || len(after.received()) != 1 {
+		t.Errorf("the other listeners received %d and %d events, want 1 each", len(before.received()), len(after.received()))
+	}
+}
+
+func TestFilterPanicIsIsolated(t *testing.T) {
+	manager := &EventManager{}
+	var filtered, other collector
+	manager.subscribeWhere(newOrder, &filtered, where(func(order NewOrder) bool {
+		if order.Total < 0 {
+			panic("negative total")
+		}
+		return true
+	}))
+	manager.subscribe(newOrder, &other)
+
+	err := manager.notify(newOrder, NewOrder{ID: "A-1", Total: -1})
+	if err == nil || !strings.Contains(err.Error(), "negative total") {
+		t.Errorf("notify returned %v, want the panic reported", err)
+	}
+	if len(filtered.received()) != 0 || len(other.received()) != 1 {
+		t.Errorf("received %d and %d events, want 0 and 1", len(filtered.received()), len(other.received()))
+	}
+	if got := manager.recipients(Event{Type: newOrder, Payload: NewOrder{Total: -1}}); len(got) != 1 {
+		t.Errorf("recipients = %v, want only the unfiltered listener", got)
+	}
+
+	// The manager is still usable afterwards.
+	done := make(chan error)
+	go func() { done <- manager.notify(newOrder, NewOrder{ID: "A-2", Total: 1}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notify blocked after a filter panicked")
+	}
+	if len(filtered.received()) != 1 {
+		t.Error("the filtered listener missed the next event")
+	}
+}
+
+func TestFilterMaySubscribe(t *testing.T) {
+	manager := &EventManager{}
+	var late collector
+	var calls atomic.Int32
+	manager.subscribeWhere(newOrder, &collector{}, func(Event) bool {
+		if calls.Add(1) == 1 {
+			manager.subscribe(newOrder, &late)
+		}
+		return true
+	})
+
+	done := make(chan struct{})
+	go func() {
+		manager.notify(newOrder, NewOrder{ID: "A-1"})
+		manager.notify(newOrder, NewOrder{ID: "A-2"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a filter subscribing from inside notify deadlocked")
+	}
+	if got := orderIDs(late.received()); len(got) != 1 || got[0] != "A-2" {
+		t.Errorf("late subscriber received %v, want [A-2]", got)
+	}
+}
+
+func TestTopicIsTyped(t *testing.T) {
+	manager := &EventManager{}
+	orders := topic[NewOrder](newOrder)
+	var got []NewOrder
+	orders.subscribe(manager, func(order NewOrder) { got = append(got, order) })
+
+	if err := orders.publish(manager, NewOrder{ID: "A-1", Total: 5}); err != nil {
+		t.Fatal(err)
+	}
+	// Other payloads on the same event type are skipped by the typed side.
+	manager.notify(newOrder, NewProduct{Name: "mug"})
+	if len(got) != 1 || got[0].ID != "A-1" {
+		t.Errorf("received %v, want only A-1", got)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Operators sit between the publisher and a listener and reshape the stream of
+events it receives. Each operator is a Listener decorator, so a chain built
+with pipe can be subscribed like any other listener:
+
+	orders := pipe(accounting,
+		filterEvents(largeOrders),
+		bufferTime(time.Minute, clock),
+	)
+	eventManager.merge(orders, newOrder, orderPaid)
+
+Time based operators take a clock so they can be driven by a manual clock in
+examples and tests instead of real timers. Their timers deliver on a goroutine
+of their own, where a panicking listener is recovered like in notify.
+Cancelling the last subscription of a chain stops its pending timers, so
+nothing buffered is delivered afterwards.
+*/
+
+// operator wraps the next listener in the chain.
+type operator func(next Listener) Listener
+
+// pipe runs events through the operators in order before they reach the
+// listener.
+func pipe(listener Listener, operators ...operator) Listener {
+	for i := len(operators) - 1; i >= 0; i-- {
+		listener = operators[i](listener)
+	}
+	return listener
+}
+
+// merge subscribes one listener to several event types.
+func (e *EventManager) merge(listener Listener, patterns ...EventType) ([]*subscriptionHandle, error) {
+	var handles []*subscriptionHandle
+	for _, pattern := range patterns {
+		handle, err := e.subscribe(pattern, listener)
+		if err != nil {
+			for _, h := range handles {
+				h.cancel()
+			}
+			return nil, err
+		}
+		handles = append(handles, handle)
+	}
+	return handles, nil
+}
+
+// stopper is implemented by listeners that hold pending timers.
+type stopper interface {
+	stop()
+}
+
+// listenerFunc turns a function into a Listener for the operators below.
+type listenerFunc struct {
+	handle func(event Event)
+	halt   func() // stops the operator's timers, nil if it has none
+	next   Listener
+}
+
+func (l *listenerFunc) update(event Event) {
+	l.handle(event)
+}
+
+// stop stops the timers of this operator and of the rest of the chain.
+func (l *listenerFunc) stop() {
+	if l.halt != nil {
+		l.halt()
+	}
+	if s, ok := l.next.(stopper); ok {
+		s.stop()
+	}
+}
+
+// deliverLater delivers from a timer. Nobody is there to receive the error,
+// but a panic must not take the process down.
+func deliverLater(next Listener, event Event) {
+	deliver(next, event)
+}
+
+func mapEvents(transform func(event Event) Event) operator {
+	return func(next Listener) Listener {
+		return &listenerFunc{next: next, handle: func(event Event) {
+			next.update(transform(event))
+		}}
+	}
+}
+
+func filterEvents(keep func(event Event) bool) operator {
+	return func(next Listener) Listener {
+		return &listenerFunc{next: next, handle: func(event Event) {
+			if keep(event) {
+				next.update(event)
+			}
+		}}
+	}
+}
+
+// distinctUntilChanged drops events whose key equals the previous event's.
+func distinctUntilChanged(key func(event Event) any) operator {
+	return func(next Listener) Listener {
+		var mu sync.Mutex
+		var last any
+		seen := false
+		return &listenerFunc{next: next, handle: func(event Event) {
+			k := key(event)
+			mu.Lock()
+			changed := !seen || !reflect.DeepEqual(k, last)
+			seen, last = true, k
+			mu.Unlock()
+			if changed {
+				next.update(event)
+			}
+		}}
+	}
+}
+
+// throttle lets the first event through and drops the following ones until
+// the interval has passed.
+func throttle(interval time.Duration, c clock) operator {
+	return func(next Listener) Listener {
+		var mu sync.Mutex
+		var until time.Time
+		return &listenerFunc{next: next, handle: func(event Event) {
+			mu.Lock()
+			now := c.now()
+			pass := !now.Before(until)
+			if pass {
+				until = now.Add(interval)
+			}
+			mu.Unlock()
+			if pass {
+				next.update(event)
+			}
+		}}
+	}
+}
+
+// debounce waits for a quiet period and then delivers only the last event.
+func debounce(quiet time.Duration, c clock) operator {
+	return func(next Listener) Listener {
+		var mu sync.Mutex
+		var pending timer
+		halt := func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if pending != nil {
+				pending.stop()
+				pending = nil
+			}
+		}
+		return &listenerFunc{next: next, halt: halt, handle: func(event Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			if pending != nil {
+				pending.stop()
+			}
+			pending = c.afterFunc(quiet, func() {
+				deliverLater(next, event)
+			})
+		}}
+	}
+}
+
+// bufferCount delivers events in batches of size. The batch is an event of
+// the last event's type whose payload is the []Event collected.
+func bufferCount(size int) operator {
+	return func(next Listener) Listener {
+		var mu sync.Mutex
+		var batch []Event
+		return &listenerFunc{next: next, handle: func(event Event) {
+			mu.Lock()
+			batch = append(batch, event)
+			if len(batch) < size {
+				mu.Unlock()
+				return
+			}
+			full := batch
+			batch = nil
+			mu.Unlock()
+			next.update(Event{Type: event.Type, Payload: full, Offset: -1})
+		}}
+	}
+}
+
+// bufferTime collects the events arriving within window of the first one
+// and delivers them as a single batch, like bufferCount.
+func bufferTime(window time.Duration, c clock) operator {
+	return func(next Listener) Listener {
+		var mu sync.Mutex
+		var batch []Event
+		var pending timer
+		halt := func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if pending != nil {
+				pending.stop()
+				pending = nil
+			}
+			batch = nil
+		}
+		return &listenerFunc{next: next, halt: halt, handle: func(event Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			batch = append(batch, event)
+			if len(batch) > 1 {
+				return
+			}
+			pending = c.afterFunc(window, func() {
+				mu.Lock()
+				full := batch
+				batch, pending = nil, nil
+				mu.Unlock()
+				if len(full) > 0 {
+					deliverLater(next, Event{Type: full[len(full)-1].Type, Payload: full, Offset: -1})
+				}
+			})
+		}}
+	}
+}
+
+type timer interface {
+	stop() bool
+}
+
+type clock interface {
+	now() time.Time
+	afterFunc(d time.Duration, f func()) timer
+}
+
+type realClock struct{}
+
+func (realClock) now() time.Time {
+	return time.Now()
+}
+
+func (realClock) afterFunc(d time.Duration, f func()) timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) stop() bool {
+	return t.t.Stop()
+}
+
+// manualClock only moves when advanced; due timers run synchronously inside
+// advance, in the order they are due.
+type manualClock struct {
+	mu      sync.Mutex
+	current time.Time
+	timers  []*manualTimer
+}
+
+type manualTimer struct {
+	clock   *manualClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newManualClock(start time.Time) *manualClock {
+	return &manualClock{current: start}
+}
+
+func (c *manualClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+func (c *manualClock) afterFunc(d time.Duration, f func()) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTimer{clock: c, at: c.current.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *manualTimer) stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.current.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+		if len(c.timers) == 0 || c.timers[0].at.After(target) {
+			c.current = target
+			c.mu.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.current = t.at
+		fire := !t.stopped
+		t.stopped = true
+		c.mu.Unlock()
+
+		if fire {
+			t.f()
+		}
+	}
+}
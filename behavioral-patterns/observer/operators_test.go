@@ -0,0 +1,281 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func product(name string, price float64) Event {
+	return Event{Type: newProduct, Payload: NewProduct{Name: name, Price: price}, Offset: -1}
+}
+
+// productNames lists the product names received, one string per event;
+// batches are joined with "+".
+func productNames(events []Event) []string {
+	var names []string
+	for _, e := range events {
+		switch payload := e.Payload.(type) {
+		case NewProduct:
+			names = append(names, payload.Name)
+		case []Event:
+			batch := ""
+			for i, inner := range payload {
+				if i > 0 {
+					batch += "+"
+				}
+				batch += inner.Payload.(NewProduct).Name
+			}
+			names = append(names, batch)
+		}
+	}
+	return names
+}
+
+func expectNames(t *testing.T, c *collector, want ...string) {
+	t.Helper()
+	if got := productNames(c.received()); !reflect.DeepEqual(got, want) {
+		t.Errorf("received %q, want %q", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	manager := &EventManager{}
+	var received collector
+	handles, err := manager.merge(&received, newProduct, orderPaid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.notify(newProduct, NewProduct{Name: "pin"})
+	manager.notify(newOrder, NewOrder{ID: "A-1"})
+	manager.notify(orderPaid, NewOrder{ID: "A-1", Paid: true})
+	if got := len(received.received()); got != 2 {
+		t.Fatalf("received %d events, want 2", got)
+	}
+
+	for _, h := range handles {
+		h.cancel()
+	}
+	manager.notify(newProduct, NewProduct{Name: "mug"})
+	if got := len(received.received()); got != 2 {
+		t.Errorf("received %d events after cancelling, want 2", got)
+	}
+
+	// A bad pattern cancels the subscriptions made so far.
+	if _, err := manager.merge(&received, newProduct, "product..created"); err == nil {
+		t.Fatal("merged an invalid pattern")
+	}
+	manager.notify(newProduct, NewProduct{Name: "socks"})
+	if got := len(received.received()); got != 2 {
+		t.Errorf("a failed merge left a subscription behind")
+	}
+}
+
+func TestFilterAndMap(t *testing.T) {
+	var received collector
+	listener := pipe(&received,
+		filterEvents(func(e Event) bool { return e.Payload.(NewProduct).Price >= 5 }),
+		mapEvents(func(e Event) Event {
+			p := e.Payload.(NewProduct)
+			p.Name = "Gopher " + p.Name
+			e.Payload = p
+			return e
+		}),
+	)
+	listener.update(product("pin", 2.99))
+	listener.update(product("mug", 9.99))
+	listener.update(product("hoodie", 39.99))
+	expectNames(t, &received, "Gopher mug", "Gopher hoodie")
+}
+
+func TestDistinctUntilChanged(t *testing.T) {
+	var received collector
+	listener := distinctUntilChanged(func(e Event) any { return e.Payload })(&received)
+	for _, name := range []string{"pin", "pin", "mug", "pin", "pin"} {
+		listener.update(product(name, 1))
+	}
+	expectNames(t, &received, "pin", "mug", "pin")
+}
+
+func TestThrottle(t *testing.T) {
+	clock := newManualClock(time.Unix(0, 0))
+	var received collector
+	listener := throttle(time.Second, clock)(&received)
+
+	steps := []struct {
+		after time.Duration
+		name  string
+	}{
+		{0, "a"},
+		{500 * time.Millisecond, "b"}, // dropped
+		{500 * time.Millisecond, "c"}, // the interval has passed
+		{999 * time.Millisecond, "d"}, // dropped
+		{time.Millisecond, "e"},
+	}
+	for _, step := range steps {
+		clock.advance(step.after)
+		listener.update(product(step.name, 1))
+	}
+	expectNames(t, &received, "a", "c", "e")
+}
+
+func TestDebounce(t *testing.T) {
+	clock := newManualClock(time.Unix(0, 0))
+	var received collector
+	listener := debounce(time.Second, clock)(&received)
+
+	listener.update(product("a", 1))
+	clock.advance(500 * time.Millisecond)
+	listener.update(product("b", 1))
+	clock.advance(999 * time.Millisecond)
+	expectNames(t, &received)
+
+	clock.advance(time.Millisecond)
+	expectNames(t, &received, "b")
+
+	listener.update(product("c", 1))
+	clock.advance(time.Second)
+	expectNames(t, &received, "b", "c")
+}
+
+func TestBufferCount(t *testing.T) {
+	var received collector
+	listener := bufferCount(2)(&received)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		listener.update(product(name, 1))
+	}
+	expectNames(t, &received, "a+b", "c+d")
+	for _, batch := range received.received() {
+		if batch.Type != newProduct || batch.Offset != -1 {
+			t.Errorf("batch event %+v", batch)
+		}
+	}
+}
+
+func TestBufferTime(t *testing.T) {
+	clock := newManualClock(time.Unix(0, 0))
+	var received collector
+	listener := bufferTime(5*time.Second, clock)(&received)
+
+	listener.update(product("a", 1))
+	clock.advance(3 * time.Second)
+	listener.update(product("b", 1))
+	clock.advance(2 * time.Second)
+	expectNames(t, &received, "a+b")
+
+	listener.update(product("c", 1))
+	clock.advance(4 * time.Second)
+	expectNames(t, &received, "a+b")
+	clock.advance(time.Second)
+	expectNames(t, &received, "a+b", "c")
+}
+
+func TestCancelStopsPendingTimers(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator func(c clock) operator
+	}{
+		{"debounce", func(c clock) operator { return debounce(time.Second, c) }},
+		{"bufferTime", func(c clock) operator { return bufferTime(time.Second, c) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newManualClock(time.Unix(0, 0))
+			var received collector
+			listener := pipe(&received, filterEvents(func(Event) bool { return true }), tt.operator(clock))
+			manager := &EventManager{}
+			handles, err := manager.merge(listener, newProduct, orderPaid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// While another subscription of the chain is left, its timers
+			// keep running.
+			manager.notify(newProduct, NewProduct{Name: "a"})
+			handles[1].cancel()
+			clock.advance(time.Second)
+			expectNames(t, &received, "a")
+
+			manager.notify(newProduct, NewProduct{Name: "b"})
+			handles[0].cancel()
+			clock.advance(time.Second)
+			expectNames(t, &received, "a")
+		})
+	}
+}
+
+// panicking panics on every event after signalling it on called.
+type panicking struct {
+	called chan struct{}
+}
+
+func (p *panicking) update(event Event) {
+	p.called <- struct{}{}
+	panic("listener bug")
+}
+
+func TestTimerDeliveryRecoversPanics(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator func(c clock) operator
+	}{
+		{"debounce", func(c clock) operator { return debounce(time.Millisecond, c) }},
+		{"bufferTime", func(c clock) operator { return bufferTime(time.Millisecond, c) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/manual clock", func(t *testing.T) {
+			clock := newManualClock(time.Unix(0, 0))
+			next := &panicking{called: make(chan struct{}, 1)}
+			tt.operator(clock)(next).update(product("a", 1))
+			clock.advance(time.Millisecond)
+			<-next.called
+		})
+		t.Run(tt.name+"/real clock", func(t *testing.T) {
+			next := &panicking{called: make(chan struct{})}
+			tt.operator(realClock{})(next).update(product("a", 1))
+			select {
+			case <-next.called:
+			case <-time.After(time.Second):
+				t.Fatal("nothing was delivered")
+			}
+			// An unrecovered panic on the timer goroutine would have
+			// crashed the test binary by now.
+			time.Sleep(10 * time.Millisecond)
+		})
+	}
+}
+
+func TestManualClockStoppedTimer(t *testing.T) {
+	clock := newManualClock(time.Unix(0, 0))
+	fired := false
+	timer := clock.afterFunc(time.Second, func() { fired = true })
+	if !timer.stop() {
+		t.Error("stop on a pending timer reported it inactive")
+	}
+	clock.advance(2 * time.Second)
+	if fired || timer.stop() {
+		t.Error("stopped timer fired or was still active")
+	}
+	if got := clock.now(); !got.Equal(time.Unix(2, 0)) {
+		t.Errorf("now = %v, want %v", got, time.Unix(2, 0))
+	}
+}
+
+func TestRealClock(t *testing.T) {
+	var c clock = realClock{}
+	fired := make(chan struct{})
+	c.afterFunc(time.Millisecond, func() { close(fired) })
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire")
+	}
+
+	stopped := c.afterFunc(time.Hour, func() { t.Error("stopped timer fired") })
+	if !stopped.stop() {
+		t.Error("stop on a pending timer reported it inactive")
+	}
+	if c.now().IsZero() {
+		t.Error("now is zero")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+/*
+Every subscription gets a handle that cancels exactly that subscription, so
+the same listener can be subscribed several times, and listeners that can't
+be compared with == (funcs, maps, slices) can still be removed.
+
+A subscription can also be limited:
+
+	times     it is cancelled after delivering that many events (1 = once)
+	ctx       it is cancelled when the context is done
+	priority  higher priorities are notified first; equal priorities keep
+	          subscription order
+*/
+
+type subscribeOptions struct {
+	filter   filter
+	priority int
+	times    int // 0 means unlimited
+	ctx      context.Context
+}
+
+type subscriptionHandle struct {
+	id      uint64
+	manager *EventManager
+}
+
+// cancel ends the subscription. It reports whether the subscription was
+// still active.
+func (h *subscriptionHandle) cancel() bool {
+	return h.manager.cancel(h.id)
+}
+
+func (e *EventManager) subscribeWith(pattern EventType, listener Listener, options subscribeOptions) (*subscriptionHandle, error) {
+	if err := validatePattern(pattern); err != nil {
+		return nil, err
+	}
+	if options.ctx != nil && options.ctx.Err() != nil {
+		return nil, options.ctx.Err()
+	}
+
+	e.mu.Lock()
+	e.nextID++
+	s := subscription{
+		id:        e.nextID,
+		pattern:   pattern,
+		listener:  listener,
+		filter:    options.filter,
+		priority:  options.priority,
+		remaining: options.times,
+		ctx:       options.ctx,
+	}
+	if s.ctx != nil {
+		s.stop = make(chan struct{})
+	}
+	// Keep the slice ordered by priority; among equals the newest goes last.
+	i := sort.Search(len(e.subscriptions), func(i int) bool {
+		return e.subscriptions[i].priority < s.priority
+	})
+	e.subscriptions = append(e.subscriptions, subscription{})
+	copy(e.subscriptions[i+1:], e.subscriptions[i:])
+	e.subscriptions[i] = s
+	e.mu.Unlock()
+
+	handle := &subscriptionHandle{id: s.id, manager: e}
+	if s.ctx != nil {
+		go func() {
+			select {
+			case <-s.ctx.Done():
+				handle.cancel()
+			case <-s.stop:
+			}
+		}()
+	}
+	return handle, nil
+}
+
+// subscribeOnce delivers at most one event to the listener.
+func (e *EventManager) subscribeOnce(pattern EventType, listener Listener) (*subscriptionHandle, error) {
+	return e.subscribeWith(pattern, listener, subscribeOptions{times: 1})
+}
+
+// cancel removes the subscription. Once a listener has no subscription
+// left, its pending timers are stopped, see operators.go.
+func (e *EventManager) cancel(id uint64) bool {
+	e.mu.Lock()
+	var removed *subscription
+	for i, s := range e.subscriptions {
+		if s.id == id {
+			s.release()
+			e.subscriptions = append(e.subscriptions[:i:i], e.subscriptions[i+1:]...)
+			removed = &s
+			break
+		}
+	}
+	unused := removed != nil && !e.subscribed(removed.listener)
+	e.mu.Unlock()
+
+	if unused {
+		if s, ok := removed.listener.(stopper); ok {
+			s.stop()
+		}
+	}
+	return removed != nil
+}
+
+// subscribed reports whether the listener still has a subscription. It must
+// be called with e.mu held.
+func (e *EventManager) subscribed(listener Listener) bool {
+	for _, s := range e.subscriptions {
+		if sameListener(s.listener, listener) {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns, in delivery order, the subscriptions whose pattern
+// matches the event, and drops the expired ones on the way. Filters are left
+// to the caller so they never run while e.mu is held.
+func (e *EventManager) candidates(event Event) []subscription {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var matching []subscription
+	kept := e.subscriptions[:0]
+	for _, s := range e.subscriptions {
+		if s.expired() {
+			s.release()
+			continue
+		}
+		if matchPattern(s.pattern, event.Type) {
+			matching = append(matching, s)
+		}
+		kept = append(kept, s)
+	}
+	clear(e.subscriptions[len(kept):])
+	e.subscriptions = kept
+	return matching
+}
+
+// claim runs the candidates' filters and returns the listeners that receive
+// the event. It uses up one delivery of the subscriptions that are limited
+// to a number of events; those cancelled or used up in the meantime are
+// skipped. Filters that panic are reported and reject the event.
+func (e *EventManager) claim(event Event, candidates []subscription) ([]Listener, []error) {
+	var failed []error
+	accepted := make(map[uint64]bool, len(candidates))
+	for _, s := range candidates {
+		ok, err := s.accepts(event)
+		if err != nil {
+			failed = append(failed, err)
+		}
+		accepted[s.id] = ok
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var listeners []Listener
+	kept := e.subscriptions[:0]
+	for _, s := range e.subscriptions {
+		if accepted[s.id] && !s.expired() {
+			listeners = append(listeners, s.listener)
+			if s.remaining == 1 {
+				s.release()
+				continue
+			}
+			if s.remaining > 1 {
+				s.remaining--
+			}
+		}
+		kept = append(kept, s)
+	}
+	clear(e.subscriptions[len(kept):])
+	e.subscriptions = kept
+	return listeners, failed
+}
+
+// accepts runs the subscription's filter, turning a panic into an error.
+func (s subscription) accepts(event Event) (ok bool, err error) {
+	if s.expired() {
+		return false, nil
+	}
+	if s.filter == nil {
+		return true, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			ok, err = false, fmt.Errorf("filter of a %s subscription panicked on %s: %v", s.pattern, event.Type, r)
+		}
+	}()
+	return s.filter(event), nil
+}
+
+// expired reports whether the subscription's context is done. The watcher
+// goroutine removes such subscriptions, but it may not have run yet.
+func (s subscription) expired() bool {
+	return s.ctx != nil && s.ctx.Err() != nil
+}
+
+// release stops the context watcher of a removed subscription.
+func (s subscription) release() {
+	if s.stop != nil {
+		close(s.stop)
+	}
+}
+
+// sameListener compares listeners without panicking on types that don't
+// support ==.
+func sameListener(a, b Listener) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb || !ta.Comparable() {
+		return false
+	}
+	return a == b
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func subscriptionCount(manager *EventManager) int {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	return len(manager.subscriptions)
+}
+
+func TestSubscribeOnce(t *testing.T) {
+	manager := &EventManager{}
+	var once collector
+	handle, err := manager.subscribeOnce(newOrder, &once)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.notify(newOrder, NewOrder{ID: "A-1"})
+	manager.notify(newOrder, NewOrder{ID: "A-2"})
+
+	if got := orderIDs(once.received()); !reflect.DeepEqual(got, []string{"A-1"}) {
+		t.Errorf("received %v, want [A-1]", got)
+	}
+	if subscriptionCount(manager) != 0 {
+		t.Error("the used up subscription was not removed")
+	}
+	if handle.cancel() {
+		t.Error("cancel reported a used up subscription as active")
+	}
+}
+
+func TestSubscribeTimes(t *testing.T) {
+	manager := &EventManager{}
+	var limited collector
+	paid := where(func(order NewOrder) bool { return order.Paid })
+	if _, err := manager.subscribeWith("order.*", &limited, subscribeOptions{times: 2, filter: paid}); err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range []NewOrder{{ID: "A-1", Paid: true}, {ID: "A-2"}, {ID: "A-3", Paid: true}, {ID: "A-4", Paid: true}} {
+		manager.notify(orderPaid, order)
+	}
+
+	// Events the filter rejects don't use up a delivery.
+	if got := orderIDs(limited.received()); !reflect.DeepEqual(got, []string{"A-1", "A-3"}) {
+		t.Errorf("received %v, want [A-1 A-3]", got)
+	}
+	if subscriptionCount(manager) != 0 {
+		t.Error("the used up subscription was not removed")
+	}
+}
+
+func TestContextCancelRemovesSubscription(t *testing.T) {
+	manager := &EventManager{}
+	ctx, cancel := context.WithCancel(context.Background())
+	var scoped collector
+	handle, err := manager.subscribeWith(newOrder, &scoped, subscribeOptions{ctx: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.notify(newOrder, NewOrder{ID: "A-1"})
+	cancel()
+	// Delivery stops as soon as the context is done, even before the
+	// subscription is removed.
+	manager.notify(newOrder, NewOrder{ID: "A-2"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for subscriptionCount(manager) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("cancelling the context did not remove the subscription")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := orderIDs(scoped.received()); !reflect.DeepEqual(got, []string{"A-1"}) {
+		t.Errorf("received %v, want [A-1]", got)
+	}
+	if handle.cancel() {
+		t.Error("cancel reported a cancelled subscription as active")
+	}
+
+	if _, err := manager.subscribeWith(newOrder, &scoped, subscribeOptions{ctx: ctx}); err == nil {
+		t.Error("subscribed with a context that is already done")
+	}
+}
+
+func TestSubscribePriority(t *testing.T) {
+	manager := &EventManager{}
+	var notified []string
+	for _, s := range []struct {
+		name     string
+		priority int
+	}{
+		{"default", 0},
+		{"first", 5},
+		{"last", -1},
+		{"second", 5},
+		{"default too", 0},
+	} {
+		listener := listen(func(NewOrder) { notified = append(notified, s.name) })
+		if _, err := manager.subscribeWith(newOrder, listener, subscribeOptions{priority: s.priority}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manager.notify(newOrder, NewOrder{ID: "A-1"})
+
+	want := []string{"first", "second", "default", "default too", "last"}
+	if !reflect.DeepEqual(notified, want) {
+		t.Errorf("notified %v, want %v", notified, want)
+	}
+}
+
+func TestHandleCancelsOnlyItsSubscription(t *testing.T) {
+	manager := &EventManager{}
+	var twice collector
+	first, _ := manager.subscribe(newOrder, &twice)
+	manager.subscribe(newOrder, &twice)
+
+	if !first.cancel() {
+		t.Error("cancel reported an active subscription as gone")
+	}
+	if first.cancel() {
+		t.Error("the same subscription was cancelled twice")
+	}
+	manager.notify(newOrder, NewOrder{ID: "A-1"})
+	if got := len(twice.received()); got != 1 {
+		t.Errorf("received %d events, want 1 through the remaining subscription", got)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Subscriptions match dotted event names segment by segment:
+
+	order.created   only that event
+	order.*         any event with exactly one segment after "order"
+	order.#         "order" itself and everything below it
+	#               every event
+
+A subscription can also carry a filter that looks at the payload, so billing
+can listen to "order.*" but only see orders that have been paid.
+*/
+
+// filter decides whether a matching event is delivered to the subscriber.
+type filter func(event Event) bool
+
+// where builds a filter from a predicate on a typed payload. Events with
+// another payload type never pass.
+func where[P any](predicate func(payload P) bool) filter {
+	return func(event Event) bool {
+		payload, ok := event.Payload.(P)
+		return ok && predicate(payload)
+	}
+}
+
+func (e *EventManager) subscribeWhere(pattern EventType, listener Listener, f filter) (*subscriptionHandle, error) {
+	return e.subscribeWith(pattern, listener, subscribeOptions{filter: f})
+}
+
+// recipients lists, in delivery order, the listeners that would receive the
+// event. Filters run outside the lock; one that panics excludes its
+// subscriber.
+func (e *EventManager) recipients(event Event) []Listener {
+	e.mu.RLock()
+	var matching []subscription
+	for _, s := range e.subscriptions {
+		if matchPattern(s.pattern, event.Type) {
+			matching = append(matching, s)
+		}
+	}
+	e.mu.RUnlock()
+
+	var listeners []Listener
+	for _, s := range matching {
+		if ok, _ := s.accepts(event); ok {
+			listeners = append(listeners, s.listener)
+		}
+	}
+	return listeners
+}
+
+func validatePattern(pattern EventType) error {
+	if pattern == "" {
+		return fmt.Errorf("empty event pattern")
+	}
+	for _, segment := range strings.Split(string(pattern), ".") {
+		if segment == "" {
+			return fmt.Errorf("event pattern %q has an empty segment", pattern)
+		}
+		if segment != "*" && segment != "#" && strings.ContainsAny(segment, "*#") {
+			return fmt.Errorf("wildcards in %q must be whole segments", pattern)
+		}
+	}
+	return nil
+}
+
+func matchPattern(pattern, eventType EventType) bool {
+	return matchSegments(strings.Split(string(pattern), "."), strings.Split(string(eventType), "."))
+}
+
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		// Either "#" matches nothing more, or it swallows one more segment.
+		return matchSegments(pattern[1:], name) || (len(name) > 0 && matchSegments(pattern, name[1:]))
+	case "*":
+		return len(name) > 0 && matchSegments(pattern[1:], name[1:])
+	default:
+		return len(name) > 0 && pattern[0] == name[0] && matchSegments(pattern[1:], name[1:])
+	}
+}
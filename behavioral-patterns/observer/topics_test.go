@@ -0,0 +1,136 @@
+package main
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, event EventType
+		want           bool
+	}{
+		{"order.created", "order.created", true},
+		{"order.created", "order.paid", false},
+		{"order.created", "order", false},
+		{"order.created", "order.created.late", false},
+		{"order.*", "order.created", true},
+		{"order.*", "order", false},
+		{"order.*", "order.created.late", false},
+		{"*.created", "product.created", true},
+		{"*.*", "order.created", true},
+		{"*", "order.created", false},
+		{"order.#", "order", true},
+		{"order.#", "order.created", true},
+		{"order.#", "order.created.late", true},
+		{"order.#", "orders.created", false},
+		{"#", "order", true},
+		{"#", "order.created.late", true},
+		{"#.created", "created", true},
+		{"#.created", "order.created", true},
+		{"#.created", "order.created.late", false},
+		{"order.#.late", "order.late", true},
+		{"order.#.late", "order.created.very.late", true},
+		{"order.#.late", "order.created.early", false},
+		{"#.#", "order", true},
+		{"*.#", "order", true},
+		{"#.*", "order.created", true},
+		{"order.*.#", "order", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.event); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	tests := []struct {
+		pattern EventType
+		valid   bool
+	}{
+		{"order.created", true},
+		{"order.*", true},
+		{"#", true},
+		{"*.#.paid", true},
+		{"", false},
+		{"order.", false},
+		{".order", false},
+		{"order..paid", false},
+		{"order.cre*", false},
+		{"order.#s", false},
+		{"**", false},
+	}
+	for _, tt := range tests {
+		if err := validatePattern(tt.pattern); (err == nil) != tt.valid {
+			t.Errorf("validatePattern(%q) = %v, want valid %v", tt.pattern, err, tt.valid)
+		}
+	}
+	if _, err := (&EventManager{}).subscribe("order.cre*", &collector{}); err == nil {
+		t.Error("subscribed with an invalid pattern")
+	}
+}
+
+func TestWhere(t *testing.T) {
+	paid := where(func(order NewOrder) bool { return order.Paid })
+	tests := []struct {
+		name  string
+		event Event
+		want  bool
+	}{
+		{"matching payload", Event{Type: orderPaid, Payload: NewOrder{Paid: true}}, true},
+		{"rejected payload", Event{Type: orderPaid, Payload: NewOrder{}}, false},
+		{"pointer payload", Event{Type: orderPaid, Payload: &NewOrder{Paid: true}}, false},
+		{"other payload type", Event{Type: orderPaid, Payload: NewProduct{}}, false},
+		{"no payload", Event{Type: orderPaid}, false},
+	}
+	for _, tt := range tests {
+		if got := paid(tt.event); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecipients(t *testing.T) {
+	manager := &EventManager{}
+	listeners := map[string]*collector{}
+	subscribe := func(name string, pattern EventType, options subscribeOptions) {
+		listeners[name] = &collector{}
+		if _, err := manager.subscribeWith(pattern, listeners[name], options); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subscribe("created", newOrder, subscribeOptions{})
+	subscribe("orders", "order.*", subscribeOptions{})
+	subscribe("everything", "#", subscribeOptions{})
+	subscribe("products", "product.*", subscribeOptions{})
+	subscribe("paid", "order.*", subscribeOptions{filter: where(func(order NewOrder) bool { return order.Paid })})
+	subscribe("urgent", "order.#", subscribeOptions{priority: 1})
+
+	tests := []struct {
+		name  string
+		event Event
+		want  []string
+	}{
+		{"unpaid order", Event{Type: newOrder, Payload: NewOrder{}}, []string{"urgent", "created", "orders", "everything"}},
+		{"paid order", Event{Type: orderPaid, Payload: NewOrder{Paid: true}}, []string{"urgent", "orders", "everything", "paid"}},
+		{"product", Event{Type: newProduct, Payload: NewProduct{}}, []string{"everything", "products"}},
+		{"unknown event", Event{Type: "refund.issued"}, []string{"everything"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := manager.recipients(tt.event)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d recipients, want %v", len(got), tt.want)
+			}
+			for i, name := range tt.want {
+				if got[i] != Listener(listeners[name]) {
+					t.Errorf("recipient %d is not %s", i, name)
+				}
+			}
+		})
+	}
+	// Asking who would receive an event delivers nothing.
+	for name, c := range listeners {
+		if len(c.received()) != 0 {
+			t.Errorf("%s received events", name)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+webhookListener forwards events to HTTP endpoints. Each event is POSTed as
+JSON and signed with the endpoint's secret:
+
+	X-Event-Type: order.created
+	X-Signature:  sha256=<hex HMAC-SHA256 of the body>
+
+Receivers verify a delivery by computing the same HMAC over the raw body.
+A non-2xx response or a network error is retried with exponential backoff;
+an endpoint whose deliveries keep failing is disabled until it is enabled
+again. The most recent attempts are kept for inspection.
+
+Retries sleep between attempts, so delivering inline would hold up notify and
+every listener after this one. Subscribe it with subscribeAsync, which runs
+the deliveries and their backoff on the subscriber's own goroutine. Retries
+are tracked per endpoint, so the queue's own retries are skipped: retrying
+the event would POST it again to the endpoints that already accepted it.
+*/
+
+const signatureHeader = "X-Signature"
+
+type webhookEndpoint struct {
+	url      string
+	secret   []byte
+	failures int // consecutive failed deliveries
+	disabled bool
+}
+
+type deliveryAttempt struct {
+	URL     string
+	Event   EventType
+	Attempt int
+	Status  int // 0 when no response was received
+	Err     error
+	At      time.Time
+}
+
+type webhookOptions struct {
+	client       *http.Client
+	maxAttempts  int           // per event and endpoint
+	backoff      time.Duration // before the second attempt, doubled each time
+	disableAfter int           // consecutive failed deliveries
+	keepAttempts int           // most recent attempts kept by deliveryAttempts
+	sleep        func(time.Duration)
+}
+
+type webhookListener struct {
+	options webhookOptions
+
+	mu        sync.Mutex
+	endpoints []*webhookEndpoint
+	attempts  []deliveryAttempt
+}
+
+func newWebhookListener(options webhookOptions) *webhookListener {
+	if options.client == nil {
+		options.client = &http.Client{Timeout: 5 * time.Second}
+	}
+	if options.maxAttempts <= 0 {
+		options.maxAttempts = 3
+	}
+	if options.backoff <= 0 {
+		options.backoff = 100 * time.Millisecond
+	}
+	if options.disableAfter <= 0 {
+		options.disableAfter = 5
+	}
+	if options.keepAttempts <= 0 {
+		options.keepAttempts = 100
+	}
+	if options.sleep == nil {
+		options.sleep = time.Sleep
+	}
+	return &webhookListener{options: options}
+}
+
+func (w *webhookListener) registerEndpoint(url, secret string) error {
+	if secret == "" {
+		return fmt.Errorf("endpoint %s needs a secret", url)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, e := range w.endpoints {
+		if e.url == url {
+			return fmt.Errorf("endpoint %s is already registered", url)
+		}
+	}
+	w.endpoints = append(w.endpoints, &webhookEndpoint{url: url, secret: []byte(secret)})
+	return nil
+}
+
+// enableEndpoint re-enables an endpoint that was disabled after failures.
+func (w *webhookListener) enableEndpoint(url string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, e := range w.endpoints {
+		if e.url == url {
+			e.disabled = false
+			e.failures = 0
+			return nil
+		}
+	}
+	return fmt.Errorf("endpoint %s is not registered", url)
+}
+
+func (w *webhookListener) disabledEndpoints() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var urls []string
+	for _, e := range w.endpoints {
+		if e.disabled {
+			urls = append(urls, e.url)
+		}
+	}
+	return urls
+}
+
+func (w *webhookListener) deliveryAttempts() []deliveryAttempt {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]deliveryAttempt(nil), w.attempts...)
+}
+
+func (w *webhookListener) update(event Event) {
+	w.tryUpdate(event)
+}
+
+// tryUpdate delivers the event to every enabled endpoint and reports the
+// endpoints that could not be reached. The error wraps errGaveUp since each
+// endpoint has been retried already.
+func (w *webhookListener) tryUpdate(event Event) error {
+	body, err := json.Marshal(struct {
+		Type    EventType `json:"type"`
+		Offset  int64     `json:"offset"`
+		Payload any       `json:"payload"`
+	}{event.Type, event.Offset, event.Payload})
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	var endpoints []*webhookEndpoint
+	for _, e := range w.endpoints {
+		if !e.disabled {
+			endpoints = append(endpoints, e)
+		}
+	}
+	w.mu.Unlock()
+
+	var errs []error
+	for _, e := range endpoints {
+		if err := w.deliverTo(e, event.Type, body); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.url, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", errGaveUp, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (w *webhookListener) deliverTo(e *webhookEndpoint, eventType EventType, body []byte) error {
+	delay := w.options.backoff
+	var err error
+	for attempt := 1; attempt <= w.options.maxAttempts; attempt++ {
+		if attempt > 1 {
+			w.options.sleep(delay)
+			delay *= 2
+		}
+
+		var status int
+		status, err = w.post(e, eventType, body)
+		w.record(deliveryAttempt{URL: e.url, Event: eventType, Attempt: attempt, Status: status, Err: err, At: time.Now()})
+		if err == nil {
+			w.mu.Lock()
+			e.failures = 0
+			w.mu.Unlock()
+			return nil
+		}
+	}
+
+	w.mu.Lock()
+	e.failures++
+	if e.failures >= w.options.disableAfter {
+		e.disabled = true
+	}
+	w.mu.Unlock()
+	return err
+}
+
+func (w *webhookListener) post(e *webhookEndpoint, eventType EventType, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", string(eventType))
+	req.Header.Set(signatureHeader, "sha256="+sign(e.secret, body))
+
+	resp, err := w.options.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (w *webhookListener) record(attempt deliveryAttempt) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.attempts) == w.options.keepAttempts {
+		w.attempts = slices.Delete(w.attempts, 0, 1)
+	}
+	w.attempts = append(w.attempts, attempt)
+}
+
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature is what a receiver runs on an incoming webhook.
+func verifySignature(secret, body []byte, header string) bool {
+	expected := "sha256=" + sign(secret, body)
+	return hmac.Equal([]byte(expected), []byte(header))
+}
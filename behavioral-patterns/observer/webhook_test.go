@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer answers with the given status codes in turn, repeating the
+// last one, and checks every request's signature against secret.
+func webhookServer(t *testing.T, secret string, statuses ...int) (*httptest.Server, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !verifySignature([]byte(secret), body, r.Header.Get(signatureHeader)) {
+			t.Errorf("bad signature %q for %s", r.Header.Get(signatureHeader), body)
+		}
+		if r.Header.Get("X-Event-Type") == "" {
+			t.Error("missing X-Event-Type header")
+		}
+		mu.Lock()
+		status := statuses[min(requests, len(statuses)-1)]
+		requests++
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestWebhookRetriesAndDisabling(t *testing.T) {
+	good, goodRequests := webhookServer(t, "good secret", http.StatusOK)
+	flaky, _ := webhookServer(t, "flaky secret", http.StatusInternalServerError, http.StatusOK)
+	broken, brokenRequests := webhookServer(t, "broken secret", http.StatusServiceUnavailable)
+
+	var slept []time.Duration
+	webhooks := newWebhookListener(webhookOptions{
+		maxAttempts:  3,
+		backoff:      10 * time.Millisecond,
+		disableAfter: 2,
+		sleep:        func(d time.Duration) { slept = append(slept, d) },
+	})
+	webhooks.registerEndpoint(good.URL, "good secret")
+	webhooks.registerEndpoint(flaky.URL, "flaky secret")
+	webhooks.registerEndpoint(broken.URL, "broken secret")
+
+	event := Event{Type: newOrder, Payload: NewOrder{ID: "W-1", Total: 10}}
+	if err := webhooks.tryUpdate(event); err == nil {
+		t.Fatal("expected the broken endpoint to fail")
+	}
+	want := []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}
+	if !reflect.DeepEqual(slept, want) {
+		t.Errorf("slept %v, want %v", slept, want)
+	}
+
+	type record struct {
+		url     string
+		attempt int
+		status  int
+		failed  bool
+	}
+	var got []record
+	for _, a := range webhooks.deliveryAttempts() {
+		if a.Event != newOrder || a.At.IsZero() {
+			t.Errorf("incomplete attempt record %+v", a)
+		}
+		got = append(got, record{a.URL, a.Attempt, a.Status, a.Err != nil})
+	}
+	wantRecords := []record{
+		{good.URL, 1, 200, false},
+		{flaky.URL, 1, 500, true},
+		{flaky.URL, 2, 200, false},
+		{broken.URL, 1, 503, true},
+		{broken.URL, 2, 503, true},
+		{broken.URL, 3, 503, true},
+	}
+	if !reflect.DeepEqual(got, wantRecords) {
+		t.Errorf("attempts:\n%v\nwant:\n%v", got, wantRecords)
+	}
+	if disabled := webhooks.disabledEndpoints(); len(disabled) != 0 {
+		t.Fatalf("disabled after one failed delivery: %v", disabled)
+	}
+
+	// A second failed delivery in a row disables the endpoint.
+	webhooks.tryUpdate(event)
+	if disabled := webhooks.disabledEndpoints(); !reflect.DeepEqual(disabled, []string{broken.URL}) {
+		t.Fatalf("disabled %v, want %v", disabled, []string{broken.URL})
+	}
+	before := brokenRequests()
+	if err := webhooks.tryUpdate(event); err != nil {
+		t.Fatalf("only enabled endpoints should be tried: %v", err)
+	}
+	if brokenRequests() != before {
+		t.Error("a disabled endpoint was called")
+	}
+
+	// Once enabled again it gets the next event.
+	if err := webhooks.enableEndpoint(broken.URL); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks.disabledEndpoints()) != 0 {
+		t.Error("endpoint still disabled after enableEndpoint")
+	}
+	webhooks.tryUpdate(event)
+	if brokenRequests() != before+3 {
+		t.Errorf("re-enabled endpoint got %d requests, want 3", brokenRequests()-before)
+	}
+	if goodRequests() != 4 {
+		t.Errorf("good endpoint got %d requests, want 4", goodRequests())
+	}
+	if err := webhooks.enableEndpoint("http://unknown"); err == nil {
+		t.Error("enabled an unregistered endpoint")
+	}
+}
+
+func TestWebhookBehindRetryingQueue(t *testing.T) {
+	good, goodRequests := webhookServer(t, "good secret", http.StatusOK)
+	broken, brokenRequests := webhookServer(t, "broken secret", http.StatusServiceUnavailable)
+
+	webhooks := newWebhookListener(webhookOptions{
+		maxAttempts: 2,
+		sleep:       func(time.Duration) {},
+	})
+	webhooks.registerEndpoint(good.URL, "good secret")
+	webhooks.registerEndpoint(broken.URL, "broken secret")
+
+	manager := &EventManager{}
+	queued, _, err := manager.subscribeAsync(newOrder, webhooks, asyncOptions{maxRetries: 3, backoff: time.Microsecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.notify(newOrder, NewOrder{ID: "W-2", Total: 10})
+	manager.Close()
+
+	if n := goodRequests(); n != 1 {
+		t.Errorf("good endpoint got %d requests, want 1", n)
+	}
+	if n := brokenRequests(); n != 2 {
+		t.Errorf("broken endpoint got %d requests, want the webhook's 2 attempts", n)
+	}
+	if stats := queued.stats(); stats != (deliveryMetrics{Failed: 1}) {
+		t.Errorf("stats = %+v, want only 1 failed", stats)
+	}
+}
+
+func TestWebhookKeepsRecentAttempts(t *testing.T) {
+	broken, _ := webhookServer(t, "secret", http.StatusServiceUnavailable)
+	webhooks := newWebhookListener(webhookOptions{
+		maxAttempts:  2,
+		disableAfter: 10,
+		keepAttempts: 3,
+		sleep:        func(time.Duration) {},
+	})
+	webhooks.registerEndpoint(broken.URL, "secret")
+
+	for _, id := range []string{"W-3", "W-4"} {
+		webhooks.tryUpdate(Event{Type: newOrder, Payload: NewOrder{ID: id}})
+	}
+	var got []int
+	for _, a := range webhooks.deliveryAttempts() {
+		got = append(got, a.Attempt)
+	}
+	if want := []int{2, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kept attempts %v, want %v", got, want)
+	}
+}
+
+func TestWebhookRegistration(t *testing.T) {
+	webhooks := newWebhookListener(webhookOptions{})
+	if err := webhooks.registerEndpoint("http://example.com", ""); err == nil {
+		t.Error("registered an endpoint without a secret")
+	}
+	webhooks.registerEndpoint("http://example.com", "secret")
+	if err := webhooks.registerEndpoint("http://example.com", "secret"); err == nil {
+		t.Error("registered the same endpoint twice")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	secret, body := []byte("s3cr3t"), []byte(`{"type":"order.created"}`)
+	header := "sha256=" + sign(secret, body)
+	if !verifySignature(secret, body, header) {
+		t.Error("valid signature rejected")
+	}
+	if verifySignature(secret, []byte(`{"type":"order.paid"}`), header) {
+		t.Error("signature accepted for a different body")
+	}
+	if verifySignature([]byte("other"), body, header) {
+		t.Error("signature accepted with a different secret")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+An AuditLog records every event fired at the vending machines attached to
+it, whether the machine accepted it or not. The sales report is derived from
+the log alone:
+
+	items sold      accepted dispenseItem events
+	revenue         the item price of each of those sales
+	rejected        failed events, counted by their error
+	out of stock    from the moment a machine entered noItem until it left
+	                it, or until the report for a machine still empty
+*/
+
+type AuditEntry struct {
+	Machine string
+	Price   int
+	Attempt[VendingState, VendingEvent]
+}
+
+type AuditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+// attach records the machine's events under name. Unlike the hooks
+// registered with VendingMachine.OnEnter and friends it runs with v.mu held,
+// so entries are logged in the order the events were fired; it must not call
+// back into v.
+func (l *AuditLog) attach(name string, v *VendingMachine) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.machine.OnAttempt(func(a Attempt[VendingState, VendingEvent]) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.entries = append(l.entries, AuditEntry{Machine: name, Price: v.itemPrice, Attempt: a})
+	})
+}
+
+func (l *AuditLog) Entries() []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]AuditEntry(nil), l.entries...)
+}
+
+type Period struct {
+	From, To time.Time
+	Ongoing  bool
+}
+
+func (p Period) Duration() time.Duration {
+	return p.To.Sub(p.From)
+}
+
+type SalesReport struct {
+	Machine    string
+	ItemsSold  int
+	Revenue    int
+	Rejected   map[string]int
+	OutOfStock []Period
+}
+
+// salesReport builds one report per machine, sorted by machine name. Periods
+// still open end at now.
+func (l *AuditLog) salesReport(now time.Time) []SalesReport {
+	reports := map[string]*SalesReport{}
+	emptySince := map[string]time.Time{}
+	for _, e := range l.Entries() {
+		r := reports[e.Machine]
+		if r == nil {
+			r = &SalesReport{Machine: e.Machine, Rejected: map[string]int{}}
+			reports[e.Machine] = r
+		}
+		if e.Err != nil {
+			r.Rejected[e.Err.Error()]++
+		} else if e.Event == DispenseItem {
+			r.ItemsSold++
+			r.Revenue += e.Price
+		}
+		if !e.Taken || e.From == e.To {
+			continue
+		}
+		switch {
+		case e.To == NoItem:
+			emptySince[e.Machine] = e.Time
+		case e.From == NoItem:
+			if since, ok := emptySince[e.Machine]; ok {
+				r.OutOfStock = append(r.OutOfStock, Period{From: since, To: e.Time})
+				delete(emptySince, e.Machine)
+			}
+		}
+	}
+	for machine, since := range emptySince {
+		r := reports[machine]
+		r.OutOfStock = append(r.OutOfStock, Period{From: since, To: now, Ongoing: true})
+	}
+
+	var sorted []SalesReport
+	for _, r := range reports {
+		sorted = append(sorted, *r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Machine < sorted[j].Machine })
+	return sorted
+}
+
+func (r SalesReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d sold, revenue %d\n", r.Machine, r.ItemsSold, r.Revenue)
+
+	reasons := make([]string, 0, len(r.Rejected))
+	for reason := range r.Rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(&b, "  rejected %dx: %s\n", r.Rejected[reason], reason)
+	}
+
+	for _, p := range r.OutOfStock {
+		end := p.To.Format("15:04")
+		if p.Ongoing {
+			end = "now"
+		}
+		fmt.Fprintf(&b, "  out of stock %s-%s (%s)\n", p.From.Format("15:04"), end, p.Duration())
+	}
+	return b.String()
+}
+
+func auditDemo() {
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	var log AuditLog
+	lobby := newVendingMachine(1, 10)
+	gym := newVendingMachine(2, 15)
+	for name, v := range map[string]*VendingMachine{"lobby": lobby, "gym": gym} {
+		v.machine.SetClock(clock)
+		log.attach(name, v)
+	}
+	lobby.OnEnter(NoItem, func() { fmt.Println("lobby: out of stock, restock needed") })
+
+	// A customer who can't pay walks away; releasing the session cancels the
+	// request.
+	buy := func(v *VendingMachine, money int) {
+		s, err := v.acquire(context.Background(), time.Minute)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		defer s.release()
+		s.requestItem()
+		s.insertMoney(money)
+		s.dispenseItem()
+		now = now.Add(10 * time.Minute)
+	}
+	buy(lobby, 10)
+	buy(lobby, 10)
+	buy(gym, 10)
+	buy(gym, 20)
+	buy(gym, 15)
+	now = now.Add(45 * time.Minute)
+	lobby.addItem(3)
+	buy(lobby, 10)
+	now = now.Add(time.Hour)
+
+	for _, r := range log.salesReport(now) {
+		fmt.Print(r)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSalesReport(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	now := start
+	var log AuditLog
+	lobby, gym := newVendingMachine(1, 10), newVendingMachine(2, 15)
+	for name, v := range map[string]*VendingMachine{"lobby": lobby, "gym": gym} {
+		v.machine.SetClock(func() time.Time { return now })
+		log.attach(name, v)
+	}
+	// The lobby sells its only item, is empty for an hour and sells again
+	// after restocking.
+	lobby.fire(RequestItem, nil)
+	lobby.fire(InsertMoney, 5)
+	lobby.fire(InsertMoney, 10)
+	lobby.fire(DispenseItem, nil)
+	now = now.Add(30 * time.Minute)
+	lobby.fire(RequestItem, nil)
+	now = now.Add(30 * time.Minute)
+	lobby.fire(AddItem, 2)
+	lobby.fire(RequestItem, nil)
+	lobby.fire(InsertMoney, 10)
+	lobby.fire(DispenseItem, nil)
+
+	// The gym counts the price, not the overpayment, and is still empty
+	// when the report is made.
+	gym.fire(RequestItem, nil)
+	gym.fire(InsertMoney, 20)
+	gym.fire(DispenseItem, nil)
+	gym.fire(DispenseItem, nil)
+	gym.fire(RequestItem, nil)
+	gym.fire(DispenseItem, nil)
+	gym.fire(RequestItem, nil)
+	gym.fire(InsertMoney, 15)
+	emptied := now
+	gym.fire(DispenseItem, nil)
+	now = now.Add(2 * time.Hour)
+
+	want := []SalesReport{
+		{
+			Machine:   "gym",
+			ItemsSold: 2,
+			Revenue:   30,
+			Rejected: map[string]int{
+				"Please select item first":  1,
+				"Please insert money first": 1,
+				"Item already requested":    1,
+			},
+			OutOfStock: []Period{{From: emptied, To: now, Ongoing: true}},
+		},
+		{
+			Machine:   "lobby",
+			ItemsSold: 2,
+			Revenue:   20,
+			Rejected: map[string]int{
+				"Inserted money is less. Please insert 10": 1,
+				"Item out of stock":                        1,
+			},
+			OutOfStock: []Period{{From: start, To: start.Add(time.Hour)}},
+		},
+	}
+	if got := log.salesReport(now); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+}
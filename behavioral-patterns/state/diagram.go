@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Since a Definition is plain data, its diagram can be generated instead of
+drawn by hand, so the docs can't drift from the code. Both formats show every
+state, an arrow from the start to the initial state and one edge per
+transition labelled with the event and, for guarded transitions, the guard's
+description:
+
+	go run . -diagram mermaid -machine multislot > multislot.mmd
+	go run . -diagram dot -machine vending | dot -Tsvg > vending.svg
+
+Refused events are not drawn; they are the absence of an edge.
+*/
+
+// states lists the states in the order they first appear in the
+// transitions, followed by states that only have refusals.
+func (d *Definition[S, E, C]) states(initial S) []S {
+	seen := map[S]bool{}
+	var states []S
+	add := func(s S) {
+		if !seen[s] {
+			seen[s] = true
+			states = append(states, s)
+		}
+	}
+	add(initial)
+	for _, t := range d.transitions {
+		add(t.From)
+		add(t.To)
+	}
+	var rest []string
+	byName := map[string]S{}
+	for s := range d.refusals {
+		if !seen[s] {
+			rest = append(rest, fmt.Sprint(s))
+			byName[fmt.Sprint(s)] = s
+		}
+	}
+	sort.Strings(rest)
+	for _, name := range rest {
+		add(byName[name])
+	}
+	return states
+}
+
+func (t Transition[S, E, C]) label() string {
+	if t.GuardName == "" {
+		return fmt.Sprint(t.Event)
+	}
+	return fmt.Sprintf("%v [%s]", t.Event, t.GuardName)
+}
+
+// DOT renders the machine as a Graphviz digraph.
+func (d *Definition[S, E, C]) DOT(name string, initial S) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", strconv.Quote(name))
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box, style=rounded];\n")
+	b.WriteString("\t__start [shape=point];\n")
+	for _, s := range d.states(initial) {
+		fmt.Fprintf(&b, "\t%s;\n", strconv.Quote(fmt.Sprint(s)))
+	}
+	fmt.Fprintf(&b, "\t__start -> %s;\n", strconv.Quote(fmt.Sprint(initial)))
+	for _, t := range d.transitions {
+		fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n",
+			strconv.Quote(fmt.Sprint(t.From)), strconv.Quote(fmt.Sprint(t.To)), strconv.Quote(t.label()))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the machine as a Mermaid state diagram.
+func (d *Definition[S, E, C]) Mermaid(initial S) string {
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	states := d.states(initial)
+	ids := mermaidIDs(states)
+	for _, s := range states {
+		if name := fmt.Sprint(s); ids[s] != name {
+			fmt.Fprintf(&b, "    state \"%s\" as %s\n", strings.ReplaceAll(mermaidText(name), `"`, "#quot;"), ids[s])
+		} else {
+			fmt.Fprintf(&b, "    %s\n", ids[s])
+		}
+	}
+	fmt.Fprintf(&b, "    [*] --> %s\n", ids[initial])
+	for _, t := range d.transitions {
+		fmt.Fprintf(&b, "    %s --> %s : %s\n", ids[t.From], ids[t.To], mermaidText(t.label()))
+	}
+	return b.String()
+}
+
+// mermaidIDs gives every state an identifier Mermaid accepts. Names that
+// had to be changed, or that clash with an earlier state's identifier, get
+// the state's index appended so no two states share an identifier; the
+// diagram then shows the real name as the state's description.
+func mermaidIDs[S comparable](states []S) map[S]string {
+	ids := make(map[S]string, len(states))
+	used := map[string]bool{}
+	for i, s := range states {
+		name := fmt.Sprint(s)
+		id := mermaidID(name)
+		if id != name || used[id] {
+			base := id
+			id = fmt.Sprintf("%s_%d", base, i)
+			for n := i + len(states); used[id]; n += len(states) {
+				id = fmt.Sprintf("%s_%d", base, n)
+			}
+		}
+		ids[s] = id
+		used[id] = true
+	}
+	return ids
+}
+
+// mermaidID replaces the characters Mermaid doesn't accept in an identifier.
+func mermaidID(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// mermaidText escapes the characters that end or break a Mermaid label.
+func mermaidText(s string) string {
+	return strings.NewReplacer(":", "#58;", ";", "#59;", "<", "#lt;", ">", "#gt;", "\n", " ").Replace(s)
+}
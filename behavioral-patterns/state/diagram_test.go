@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestMermaidIDsAreUnique(t *testing.T) {
+	states := []string{"a b", "a-b", "a_b", "a_b_0", "a.b", "ok"}
+	ids := mermaidIDs(states)
+	seen := map[string]string{}
+	for _, s := range states {
+		id := ids[s]
+		if id != mermaidID(id) {
+			t.Errorf("%q got identifier %q, which Mermaid doesn't accept", s, id)
+		}
+		if other, ok := seen[id]; ok {
+			t.Errorf("%q and %q share the identifier %q", other, s, id)
+		}
+		seen[id] = s
+	}
+	if ids["ok"] != "ok" || ids["a_b"] != "a_b" {
+		t.Errorf("valid names were changed: %v", ids)
+	}
+}
+
+func TestMermaidShowsRenamedStatesByName(t *testing.T) {
+	d := NewDefinition[string, string, any]().Allow(
+		Transition[string, string, any]{From: "in stock", Event: "sell", To: "in-stock"},
+	)
+	want := `stateDiagram-v2
+    state "in stock" as in_stock_0
+    state "in-stock" as in_stock_1
+    [*] --> in_stock_0
+    in_stock_0 --> in_stock_1 : sell
+`
+	if got := d.Mermaid("in stock"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// doorDefinition covers a guarded transition, a label that needs escaping
+// and a state that only has refusals.
+var doorDefinition = NewDefinition[string, string, any]().
+	Allow(
+		Transition[string, string, any]{From: "closed", Event: "open", To: "open"},
+		Transition[string, string, any]{From: "open", Event: "close", To: "closed"},
+		Transition[string, string, any]{
+			From: "closed", Event: "lock", To: "locked",
+			Guard:     func(any, any) error { return nil },
+			GuardName: "key: tries < 3",
+		},
+	).
+	Refuse("jammed", "the door is stuck", "open")
+
+func TestDOT(t *testing.T) {
+	want := `digraph "door" {
+	rankdir=LR;
+	node [shape=box, style=rounded];
+	__start [shape=point];
+	"closed";
+	"open";
+	"locked";
+	"jammed";
+	__start -> "closed";
+	"closed" -> "open" [label="open"];
+	"open" -> "closed" [label="close"];
+	"closed" -> "locked" [label="lock [key: tries < 3]"];
+}
+`
+	if got := doorDefinition.DOT("door", "closed"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMermaid(t *testing.T) {
+	want := `stateDiagram-v2
+    closed
+    open
+    locked
+    jammed
+    [*] --> closed
+    closed --> open : open
+    open --> closed : close
+    closed --> locked : lock [key#58; tries #lt; 3]
+`
+	if got := doorDefinition.Mermaid("closed"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
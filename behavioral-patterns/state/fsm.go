@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+The State pattern needs one struct per state, and every state has to
+implement every method even when all it does is return an error. The engine
+below declares a state machine as data instead:
+
+	states       any comparable values, usually string constants
+	events       what can happen to the machine, also comparable values
+	transitions  from + event -> to, with an optional guard and action
+	refusals     why an event is rejected in a state
+
+Several transitions may share a source state and an event; the first one
+whose guard passes is taken. An event without a transition fails with an
+*InvalidEventError and a guard that rejects it with a *GuardError, so callers
+can tell the two apart with errors.As while the messages stay readable.
+
+The definition is shared by every machine built from it; a Machine only holds
+its current state, the context passed to guards and actions, and its hooks:
+
+	OnExit, OnEnter  run when the machine leaves or enters a state, after the
+	                 transition's action; self transitions run neither
+	OnTransition     is told about every transition taken
+	OnAttempt        is told about every event fired, taken or rejected
+
+They run inside Fire, in the order exit, enter, transition, attempt. A Machine
+is not safe for concurrent use; VendingMachine wraps one with a mutex and runs
+its hooks once the mutex is released.
+*/
+
+var ErrInvalidEvent = errors.New("invalid event")
+
+// Transition moves the machine from From to To when Event fires.
+type Transition[S, E comparable, C any] struct {
+	From  S
+	Event E
+	To    S
+
+	// Guard, when set, must return nil for the transition to be taken.
+	// GuardName describes the condition, e.g. "money >= price".
+	Guard     func(ctx C, arg any) error
+	GuardName string
+
+	// Action runs before the machine enters To; an error cancels the
+	// transition.
+	Action func(ctx C, arg any) error
+
+	// Err, when set, is returned after the transition is taken, for
+	// transitions that move the machine but still refuse the event.
+	Err error
+}
+
+type Definition[S, E comparable, C any] struct {
+	transitions []Transition[S, E, C]
+	refusals    map[S]map[E]string
+}
+
+func NewDefinition[S, E comparable, C any]() *Definition[S, E, C] {
+	return &Definition[S, E, C]{refusals: map[S]map[E]string{}}
+}
+
+// Allow adds transitions. Transitions with the same source state and event
+// are tried in the order they were added.
+func (d *Definition[S, E, C]) Allow(transitions ...Transition[S, E, C]) *Definition[S, E, C] {
+	d.transitions = append(d.transitions, transitions...)
+	return d
+}
+
+// Refuse sets the reason reported when one of the events fires in state.
+func (d *Definition[S, E, C]) Refuse(state S, reason string, events ...E) *Definition[S, E, C] {
+	if d.refusals[state] == nil {
+		d.refusals[state] = map[E]string{}
+	}
+	for _, event := range events {
+		d.refusals[state][event] = reason
+	}
+	return d
+}
+
+// knows reports whether the state appears in a transition or refusal.
+func (d *Definition[S, E, C]) knows(state S) bool {
+	if _, ok := d.refusals[state]; ok {
+		return true
+	}
+	for _, t := range d.transitions {
+		if t.From == state || t.To == state {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Definition[S, E, C]) candidates(from S, event E) []Transition[S, E, C] {
+	var candidates []Transition[S, E, C]
+	for _, t := range d.transitions {
+		if t.From == from && t.Event == event {
+			candidates = append(candidates, t)
+		}
+	}
+	return candidates
+}
+
+type Machine[S, E comparable, C any] struct {
+	definition *Definition[S, E, C]
+	state      S
+	ctx        C
+
+	now          func() time.Time
+	enter        map[S][]func()
+	exit         map[S][]func()
+	onTransition []func(Attempt[S, E])
+	onAttempt    []func(Attempt[S, E])
+}
+
+func NewMachine[S, E comparable, C any](definition *Definition[S, E, C], initial S, ctx C) *Machine[S, E, C] {
+	return &Machine[S, E, C]{
+		definition: definition,
+		state:      initial,
+		ctx:        ctx,
+		now:        time.Now,
+		enter:      map[S][]func(){},
+		exit:       map[S][]func(){},
+	}
+}
+
+// Attempt describes one event fired at the machine.
+type Attempt[S, E comparable] struct {
+	Time  time.Time
+	Event E
+	Arg   any
+	From  S
+	To    S    // the state after the event, From if no transition was taken
+	Taken bool // a transition was taken, possibly still returning Err
+	Err   error
+}
+
+func (m *Machine[S, E, C]) OnEnter(state S, hook func()) {
+	m.enter[state] = append(m.enter[state], hook)
+}
+
+func (m *Machine[S, E, C]) OnExit(state S, hook func()) {
+	m.exit[state] = append(m.exit[state], hook)
+}
+
+func (m *Machine[S, E, C]) OnTransition(listener func(Attempt[S, E])) {
+	m.onTransition = append(m.onTransition, listener)
+}
+
+func (m *Machine[S, E, C]) OnAttempt(listener func(Attempt[S, E])) {
+	m.onAttempt = append(m.onAttempt, listener)
+}
+
+// SetClock replaces the clock used to time attempts.
+func (m *Machine[S, E, C]) SetClock(now func() time.Time) {
+	m.now = now
+}
+
+func (m *Machine[S, E, C]) State() S {
+	return m.state
+}
+
+// Fire takes the first transition for the event whose guard passes.
+func (m *Machine[S, E, C]) Fire(event E, arg any) error {
+	attempt := Attempt[S, E]{Time: m.now(), Event: event, Arg: arg, From: m.state}
+	attempt.Taken, attempt.Err = m.fire(event, arg)
+	attempt.To = m.state
+	if attempt.Taken {
+		for _, listener := range m.onTransition {
+			listener(attempt)
+		}
+	}
+	for _, listener := range m.onAttempt {
+		listener(attempt)
+	}
+	return attempt.Err
+}
+
+func (m *Machine[S, E, C]) fire(event E, arg any) (bool, error) {
+	candidates := m.definition.candidates(m.state, event)
+	if len(candidates) == 0 {
+		return false, &InvalidEventError[S, E]{State: m.state, Event: event, Reason: m.definition.refusals[m.state][event]}
+	}
+
+	var rejected *GuardError[S, E]
+	for _, t := range candidates {
+		if t.Guard != nil {
+			if err := t.Guard(m.ctx, arg); err != nil {
+				if rejected == nil {
+					rejected = &GuardError[S, E]{State: m.state, Event: event, Guard: t.GuardName, Err: err}
+				}
+				continue
+			}
+		}
+		if t.Action != nil {
+			if err := t.Action(m.ctx, arg); err != nil {
+				return false, err
+			}
+		}
+		if t.To == m.state {
+			return true, t.Err
+		}
+		for _, hook := range m.exit[m.state] {
+			hook()
+		}
+		m.state = t.To
+		for _, hook := range m.enter[m.state] {
+			hook()
+		}
+		return true, t.Err
+	}
+	return false, rejected
+}
+
+// InvalidEventError is returned for an event the current state has no
+// transition for.
+type InvalidEventError[S, E comparable] struct {
+	State  S
+	Event  E
+	Reason string
+}
+
+func (e *InvalidEventError[S, E]) Error() string {
+	if e.Reason != "" {
+		return e.Reason
+	}
+	return fmt.Sprintf("event %v is not allowed in state %v", e.Event, e.State)
+}
+
+func (e *InvalidEventError[S, E]) Is(target error) bool {
+	return target == ErrInvalidEvent
+}
+
+// GuardError is returned when every transition for the event was rejected
+// by its guard. It carries the error of the first guard tried.
+type GuardError[S, E comparable] struct {
+	State S
+	Event E
+	Guard string
+	Err   error
+}
+
+func (e *GuardError[S, E]) Error() string {
+	return e.Err.Error()
+}
+
+func (e *GuardError[S, E]) Unwrap() error {
+	return e.Err
+}
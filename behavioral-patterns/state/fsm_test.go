@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type light struct{ blocked bool }
+
+var lightDefinition = NewDefinition[string, string, *light]().
+	Allow(
+		Transition[string, string, *light]{From: "off", Event: "toggle", To: "on"},
+		Transition[string, string, *light]{From: "on", Event: "toggle", To: "off"},
+		Transition[string, string, *light]{From: "on", Event: "dim", To: "on"},
+		Transition[string, string, *light]{
+			From: "off", Event: "dim", To: "on",
+			Guard: func(l *light, arg any) error {
+				if l.blocked {
+					return errors.New("blocked")
+				}
+				return nil
+			},
+		},
+	).
+	Refuse("off", "the light is off", "flash")
+
+// recordHooks registers every kind of hook and returns what they saw.
+func recordHooks(m *Machine[string, string, *light]) *[]string {
+	var calls []string
+	for _, state := range []string{"on", "off"} {
+		m.OnExit(state, func() { calls = append(calls, "exit "+state) })
+		m.OnEnter(state, func() { calls = append(calls, "enter "+state) })
+	}
+	m.OnTransition(func(a Attempt[string, string]) {
+		calls = append(calls, fmt.Sprintf("transition %s: %s -> %s", a.Event, a.From, a.To))
+	})
+	m.OnAttempt(func(a Attempt[string, string]) {
+		calls = append(calls, fmt.Sprintf("attempt %s: taken %v, err %v", a.Event, a.Taken, a.Err))
+	})
+	return &calls
+}
+
+func TestHookOrder(t *testing.T) {
+	m := NewMachine(lightDefinition, "off", &light{})
+	calls := recordHooks(m)
+	if err := m.Fire("toggle", nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"exit off",
+		"enter on",
+		"transition toggle: off -> on",
+		"attempt toggle: taken true, err <nil>",
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("hooks ran as\n%q\nwant\n%q", *calls, want)
+	}
+}
+
+func TestSelfTransitionSkipsExitAndEnter(t *testing.T) {
+	m := NewMachine(lightDefinition, "on", &light{})
+	calls := recordHooks(m)
+	if err := m.Fire("dim", nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"transition dim: on -> on",
+		"attempt dim: taken true, err <nil>",
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("hooks ran as\n%q\nwant\n%q", *calls, want)
+	}
+}
+
+func TestRejectedEventsOnlyReachAttemptListeners(t *testing.T) {
+	m := NewMachine(lightDefinition, "off", &light{blocked: true})
+	calls := recordHooks(m)
+
+	err := m.Fire("flash", nil)
+	if !errors.Is(err, ErrInvalidEvent) || err.Error() != "the light is off" {
+		t.Errorf("flash: got %v", err)
+	}
+	var guard *GuardError[string, string]
+	if err := m.Fire("dim", nil); !errors.As(err, &guard) {
+		t.Errorf("dim: got %v, want a guard error", err)
+	}
+	want := []string{
+		"attempt flash: taken false, err the light is off",
+		"attempt dim: taken false, err blocked",
+	}
+	if !reflect.DeepEqual(*calls, want) || m.State() != "off" {
+		t.Errorf("hooks ran as\n%q\nwant\n%q\nstate %s", *calls, want, m.State())
+	}
+}
+
+func TestVendingMachineHooksMayCallBack(t *testing.T) {
+	v := newVendingMachine(1, 10)
+	var calls []string
+	v.OnExit(HasItem, func() { calls = append(calls, "left hasItem, now "+string(v.state())) })
+	v.OnEnter(NoItem, func() {
+		calls = append(calls, "entered noItem, now "+string(v.state()))
+		// Restocking from inside a hook must not deadlock.
+		if err := v.addItem(5); err != nil {
+			t.Error(err)
+		}
+	})
+	v.OnTransition(func(a Attempt[VendingState, VendingEvent]) {
+		calls = append(calls, fmt.Sprintf("%s -> %s", a.From, a.To))
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s, err := v.acquire(t.Context(), time.Minute)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer s.release()
+		s.requestItem()
+		s.insertMoney(10)
+		s.dispenseItem()
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("a hook calling back into the machine deadlocked")
+	}
+
+	// The restock's transition runs after the hooks of the sale that
+	// triggered it.
+	want := []string{
+		"left hasItem, now itemRequested",
+		"hasItem -> itemRequested",
+		"itemRequested -> hasMoney",
+		"entered noItem, now noItem",
+		"hasMoney -> noItem",
+		"noItem -> hasItem",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("hooks ran as\n%q\nwant\n%q", calls, want)
+	}
+	if v.state() != HasItem || v.itemCount != 5 {
+		t.Errorf("state %s with %d items, want hasItem with 5", v.state(), v.itemCount)
+	}
+}
+
+// TestVendingMachineKeepsOriginalBehaviour replays the original demo and
+// every refusal of the state structs the definition replaced, checking the
+// state and error after each step.
+func TestVendingMachineKeepsOriginalBehaviour(t *testing.T) {
+	type step struct {
+		event   VendingEvent
+		arg     any
+		state   VendingState
+		items   int
+		wantErr string
+	}
+	tests := []struct {
+		name  string
+		items int
+		steps []step
+	}{
+		{
+			name:  "original demo",
+			items: 1,
+			steps: []step{
+				{event: RequestItem, state: ItemRequested, items: 1},
+				{event: InsertMoney, arg: 5, state: ItemRequested, items: 1, wantErr: "Inserted money is less. Please insert 10"},
+				{event: InsertMoney, arg: 10, state: HasMoney, items: 1},
+				{event: DispenseItem, state: NoItem, items: 0},
+				{event: RequestItem, state: NoItem, items: 0, wantErr: "Item out of stock"},
+				{event: AddItem, arg: 2, state: HasItem, items: 2},
+				{event: RequestItem, state: ItemRequested, items: 2},
+				{event: InsertMoney, arg: 10, state: HasMoney, items: 2},
+				{event: DispenseItem, state: HasItem, items: 1},
+			},
+		},
+		{
+			name:  "refusals without stock",
+			items: 0,
+			steps: []step{
+				{event: RequestItem, state: NoItem, wantErr: "No item present"},
+				{event: InsertMoney, arg: 10, state: NoItem, wantErr: "Item out of stock"},
+				{event: DispenseItem, state: NoItem, wantErr: "Item out of stock"},
+				{event: AddItem, arg: 1, state: HasItem, items: 1},
+			},
+		},
+		{
+			name:  "refusals during a purchase",
+			items: 1,
+			steps: []step{
+				{event: InsertMoney, arg: 10, state: HasItem, items: 1, wantErr: "Please select item first"},
+				{event: DispenseItem, state: HasItem, items: 1, wantErr: "Please select item first"},
+				{event: AddItem, arg: 1, state: HasItem, items: 2},
+				{event: RequestItem, state: ItemRequested, items: 2},
+				{event: RequestItem, state: ItemRequested, items: 2, wantErr: "Item already requested"},
+				{event: AddItem, arg: 1, state: ItemRequested, items: 2, wantErr: "Item Dispense in progress"},
+				{event: DispenseItem, state: ItemRequested, items: 2, wantErr: "Please insert money first"},
+				{event: InsertMoney, arg: 10, state: HasMoney, items: 2},
+				{event: RequestItem, state: HasMoney, items: 2, wantErr: "Item dispense in progress"},
+				{event: AddItem, arg: 1, state: HasMoney, items: 2, wantErr: "Item dispense in progress"},
+				{event: InsertMoney, arg: 10, state: HasMoney, items: 2, wantErr: "Item out of stock"},
+				{event: DispenseItem, state: HasItem, items: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newVendingMachine(tt.items, 10)
+			for i, s := range tt.steps {
+				err := v.fire(s.event, s.arg)
+				gotErr := ""
+				if err != nil {
+					gotErr = err.Error()
+				}
+				if gotErr != s.wantErr {
+					t.Fatalf("step %d (%s): error %q, want %q", i, s.event, gotErr, s.wantErr)
+				}
+				if v.state() != s.state || v.itemCount != s.items {
+					t.Fatalf("step %d (%s): state %s with %d items, want %s with %d", i, s.event, v.state(), v.itemCount, s.state, s.items)
+				}
+			}
+		})
+	}
+}
+
+func TestVendingMachineReturnsChange(t *testing.T) {
+	v := newVendingMachine(2, 10)
+	for _, step := range []struct {
+		event VendingEvent
+		arg   any
+	}{
+		{RequestItem, nil}, {InsertMoney, 25}, {DispenseItem, nil},
+		{RequestItem, nil}, {InsertMoney, 10}, {DispenseItem, nil},
+	} {
+		if err := v.fire(step.event, step.arg); err != nil {
+			t.Fatalf("%s: %v", step.event, err)
+		}
+	}
+	if v.returned != 15 || v.credit != 0 {
+		t.Errorf("returned %d with credit %d left, want 15 and 0", v.returned, v.credit)
+	}
+}
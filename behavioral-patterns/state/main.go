@@ -1,6 +1,14 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
 
 /*
 https://refactoring.guru/design-patterns/state
@@ -73,175 +81,332 @@ Select the item
 1. Add the item
 2. Insert money
 3. Dispense item
+
+The states, events and transitions below are declared as data for the engine
+in fsm.go instead of as one struct per state.
 */
 
+type VendingState string
+
+const (
+	HasItem       VendingState = "hasItem"
+	ItemRequested VendingState = "itemRequested"
+	HasMoney      VendingState = "hasMoney"
+	NoItem        VendingState = "noItem"
+)
+
+type VendingEvent string
+
+const (
+	RequestItem  VendingEvent = "requestItem"
+	AddItem      VendingEvent = "addItem"
+	InsertMoney  VendingEvent = "insertMoney"
+	DispenseItem VendingEvent = "dispenseItem"
+	// CancelRequest abandons a started purchase and returns the money.
+	CancelRequest VendingEvent = "cancelRequest"
+)
+
+var vendingMachineDefinition = NewDefinition[VendingState, VendingEvent, *VendingMachine]().
+	Allow(
+		Transition[VendingState, VendingEvent, *VendingMachine]{
+			From: NoItem, Event: AddItem, To: HasItem,
+			Action: func(v *VendingMachine, arg any) error {
+				v.incrementItemCount(arg.(int))
+				return nil
+			},
+		},
+		Transition[VendingState, VendingEvent, *VendingMachine]{
+			From: HasItem, Event: RequestItem, To: NoItem,
+			Guard:     outOfStock,
+			GuardName: "itemCount == 0",
+			Err:       errors.New("No item present"),
+		},
+		Transition[VendingState, VendingEvent, *VendingMachine]{
+			From: HasItem, Event: RequestItem, To: ItemRequested,
+			Action: func(v *VendingMachine, arg any) error {
+				fmt.Printf("Item requestd\n")
+				return nil
+			},
+		},
+		Transition[VendingState, VendingEvent, *VendingMachine]{
+			From: HasItem, Event: AddItem, To: HasItem,
+			Action: func(v *VendingMachine, arg any) error {
+				fmt.Printf("%d items added\n", arg.(int))
+				v.incrementItemCount(arg.(int))
+				return nil
+			},
+		},
+		Transition[VendingState, VendingEvent, *VendingMachine]{
+			From: ItemRequested, Event: InsertMoney, To: HasMoney,
+			Guard:     enoughMoney,
+			GuardName: "money >= itemPrice",
+			Action: func(v *VendingMachine, arg any) error {
+				fmt.Println("Money entered is ok")
+				v.credit = arg.(int)
+				return nil
+			},
+		},
+		Transition[VendingState, VendingEvent, *VendingMachine]{
+			From: HasMoney, Event: DispenseItem, To: NoItem,
+			Guard:     lastItem,
+			GuardName: "itemCount == 1",
+			Action:    dispense,
+		},
+		Transition[VendingState, VendingEvent, *VendingMachine]{
+			From: HasMoney, Event: DispenseItem, To: HasItem,
+			Action: dispense,
+		},
+		Transition[VendingState, VendingEvent, *VendingMachine]{
+			From: ItemRequested, Event: CancelRequest, To: HasItem,
+		},
+		Transition[VendingState, VendingEvent, *VendingMachine]{
+			From: HasMoney, Event: CancelRequest, To: HasItem,
+			Action: func(v *VendingMachine, arg any) error {
+				fmt.Printf("Returning %d\n", v.credit)
+				v.returned += v.credit
+				v.credit = 0
+				return nil
+			},
+		},
+	).
+	Refuse(NoItem, "Item out of stock", RequestItem, InsertMoney, DispenseItem).
+	Refuse(HasItem, "Please select item first", InsertMoney, DispenseItem).
+	Refuse(ItemRequested, "Item already requested", RequestItem).
+	Refuse(ItemRequested, "Item Dispense in progress", AddItem).
+	Refuse(ItemRequested, "Please insert money first", DispenseItem).
+	Refuse(HasMoney, "Item dispense in progress", RequestItem, AddItem).
+	Refuse(HasMoney, "Item out of stock", InsertMoney).
+	Refuse(HasItem, "No item requested", CancelRequest).
+	Refuse(NoItem, "No item requested", CancelRequest)
+
+func outOfStock(v *VendingMachine, arg any) error {
+	if v.itemCount != 0 {
+		return fmt.Errorf("%d items in stock", v.itemCount)
+	}
+	return nil
+}
+
+func enoughMoney(v *VendingMachine, arg any) error {
+	if arg.(int) < v.itemPrice {
+		return fmt.Errorf("Inserted money is less. Please insert %d", v.itemPrice)
+	}
+	return nil
+}
+
+func lastItem(v *VendingMachine, arg any) error {
+	if v.itemCount != 1 {
+		return fmt.Errorf("%d items left", v.itemCount)
+	}
+	return nil
+}
+
+// dispense hands out the item and pays back whatever was inserted above
+// the price.
+func dispense(v *VendingMachine, arg any) error {
+	fmt.Println("Dispensing Item")
+	v.itemCount = v.itemCount - 1
+	if change := v.credit - v.itemPrice; change > 0 {
+		fmt.Printf("Returning change %d\n", change)
+		v.returned += change
+	}
+	v.credit = 0
+	return nil
+}
+
 // Context
 type VendingMachine struct {
-	hasItem       State
-	itemRequested State
-	hasMoney      State
-	noItem        State
-
-	currentState State
+	machine *Machine[VendingState, VendingEvent, *VendingMachine]
 
 	itemCount int
 	itemPrice int
+	credit    int // money inserted for the requested item
+	returned  int // money paid back so far, as change or refunds
+
+	snapshotPath string // where every transition is saved, if set
+
+	mu       sync.Mutex    // serializes events
+	pending  []func()      // hooks to run once mu is released
+	draining bool          // a goroutine is running the pending hooks
+	sessions chan struct{} // holds a token while a customer has a session
 }
 
 func newVendingMachine(itemCount, itemPrice int) *VendingMachine {
 	v := &VendingMachine{
 		itemCount: itemCount,
 		itemPrice: itemPrice,
+		sessions:  make(chan struct{}, 1),
 	}
-	hasItemState := &HasItemState{
-		vendingMachine: v,
-	}
-	itemRequestedState := &ItemRequestedState{
-		vendingMachine: v,
-	}
-	hasMoneyState := &HasMoneyState{
-		vendingMachine: v,
-	}
-	noItemState := &NoItemState{
-		vendingMachine: v,
-	}
-
-	v.setState(hasItemState)
-	v.hasItem = hasItemState
-	v.itemRequested = itemRequestedState
-	v.hasMoney = hasMoneyState
-	v.noItem = noItemState
+	v.machine = NewMachine(vendingMachineDefinition, HasItem, v)
 	return v
 }
 
-func (v *VendingMachine) requestItem() error {
-	return v.currentState.requestItem()
-}
-
+// addItem restocks the machine. Buying goes through a Session, see
+// session.go.
 func (v *VendingMachine) addItem(count int) error {
-	return v.currentState.addItem(count)
+	return v.fire(AddItem, count)
 }
 
-func (v *VendingMachine) insertMoney(money int) error {
-	return v.currentState.insertMoney(money)
-}
-
-func (v *VendingMachine) dispenseItem() error {
-	return v.currentState.dispenseItem()
-}
-
-func (v *VendingMachine) setState(s State) {
-	v.currentState = s
-}
-
-func (v *VendingMachine) incrementItemCount(count int) {
-	fmt.Printf("Adding %d items\n", count)
-	v.itemCount = v.itemCount + count
+// fire sends the event to the state machine and saves the machine when it
+// changed. The hooks the event triggered run after the machine is unlocked.
+func (v *VendingMachine) fire(event VendingEvent, arg any) error {
+	v.mu.Lock()
+	before := v.snapshot()
+	err := v.machine.Fire(event, arg)
+	if v.snapshotPath != "" && v.snapshot() != before {
+		if saveErr := v.save(); saveErr != nil {
+			err = errors.Join(err, saveErr)
+		}
+	}
+	v.runHooks()
+	return err
 }
 
-// State interface
-type State interface {
-	addItem(int) error
-	requestItem() error
-	insertMoney(money int) error
-	dispenseItem() error
+// runHooks runs the pending hooks without holding mu and unlocks it. Only
+// one goroutine runs hooks at a time: events fired meanwhile, including by
+// the hooks themselves, queue theirs behind, so hooks run in the order the
+// events were fired.
+func (v *VendingMachine) runHooks() {
+	defer v.mu.Unlock()
+	if v.draining {
+		return
+	}
+	v.draining = true
+	for len(v.pending) > 0 {
+		hook := v.pending[0]
+		v.pending = v.pending[1:]
+		v.mu.Unlock()
+		hook()
+		v.mu.Lock()
+	}
+	v.draining = false
 }
 
-// Concrete state
-type NoItemState struct {
-	vendingMachine *VendingMachine
+// OnEnter, OnExit and OnTransition register hooks like the Machine methods
+// of the same name. The hooks run once the event has been handled and the
+// machine unlocked, so they may call back into it, e.g. to restock.
+func (v *VendingMachine) OnEnter(state VendingState, hook func()) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.machine.OnEnter(state, func() { v.pending = append(v.pending, hook) })
 }
 
-func (i *NoItemState) requestItem() error {
-	return fmt.Errorf("Item out of stock")
+func (v *VendingMachine) OnExit(state VendingState, hook func()) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.machine.OnExit(state, func() { v.pending = append(v.pending, hook) })
 }
 
-func (i *NoItemState) addItem(count int) error {
-	i.vendingMachine.incrementItemCount(count)
-	i.vendingMachine.setState(i.vendingMachine.hasItem)
-	return nil
+func (v *VendingMachine) OnTransition(listener func(Attempt[VendingState, VendingEvent])) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.machine.OnTransition(func(a Attempt[VendingState, VendingEvent]) {
+		v.pending = append(v.pending, func() { listener(a) })
+	})
 }
 
-func (i *NoItemState) insertMoney(money int) error {
-	return fmt.Errorf("Item out of stock")
-}
-func (i *NoItemState) dispenseItem() error {
-	return fmt.Errorf("Item out of stock")
+func (v *VendingMachine) state() VendingState {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.machine.State()
 }
 
-type HasItemState struct {
-	vendingMachine *VendingMachine
+func (v *VendingMachine) incrementItemCount(count int) {
+	fmt.Printf("Adding %d items\n", count)
+	v.itemCount = v.itemCount + count
 }
 
-func (i *HasItemState) requestItem() error {
-	if i.vendingMachine.itemCount == 0 {
-		i.vendingMachine.setState(i.vendingMachine.noItem)
-		return fmt.Errorf("No item present")
+// diagram returns the chosen machine's diagram in the given format.
+func diagram(machine, format string) (string, error) {
+	type renderer struct {
+		dot     func() string
+		mermaid func() string
 	}
-	fmt.Printf("Item requestd\n")
-	i.vendingMachine.setState(i.vendingMachine.itemRequested)
-	return nil
-}
-
-func (i *HasItemState) addItem(count int) error {
-	fmt.Printf("%d items added\n", count)
-	i.vendingMachine.incrementItemCount(count)
-	return nil
-}
-
-func (i *HasItemState) insertMoney(money int) error {
-	return fmt.Errorf("Please select item first")
-}
-func (i *HasItemState) dispenseItem() error {
-	return fmt.Errorf("Please select item first")
-}
-
-type ItemRequestedState struct {
-	vendingMachine *VendingMachine
+	machines := map[string]renderer{
+		"vending": {
+			dot:     func() string { return vendingMachineDefinition.DOT("vending", HasItem) },
+			mermaid: func() string { return vendingMachineDefinition.Mermaid(HasItem) },
+		},
+		"multislot": {
+			dot:     func() string { return multiSlotDefinition.DOT("multislot", Idle) },
+			mermaid: func() string { return multiSlotDefinition.Mermaid(Idle) },
+		},
+	}
+	r, ok := machines[machine]
+	if !ok {
+		return "", fmt.Errorf("unknown machine %q, want vending or multislot", machine)
+	}
+	switch format {
+	case "dot":
+		return r.dot(), nil
+	case "mermaid":
+		return r.mermaid(), nil
+	}
+	return "", fmt.Errorf("unknown diagram format %q, want dot or mermaid", format)
 }
 
-func (i *ItemRequestedState) requestItem() error {
-	return fmt.Errorf("Item already requested")
-}
+func main() {
+	format := flag.String("diagram", "", "print the state diagram as dot or mermaid and exit")
+	machine := flag.String("machine", "vending", "machine to draw: vending or multislot")
+	snapshot := flag.String("snapshot", "", "file the vending machine is restored from and saved to")
+	flag.Parse()
+
+	if *format != "" {
+		out, err := diagram(*machine, *format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		fmt.Print(out)
+		return
+	}
 
-func (i *ItemRequestedState) addItem(count int) error {
-	return fmt.Errorf("Item Dispense in progress")
-}
+	vendingMachine := newVendingMachine(1, 10)
+	if *snapshot != "" {
+		var err error
+		if vendingMachine, err = openVendingMachine(*snapshot, 1, 10); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 
-func (i *ItemRequestedState) insertMoney(money int) error {
-	if money < i.vendingMachine.itemPrice {
-		return fmt.Errorf("Inserted money is less. Please insert %d", i.vendingMachine.itemPrice)
+	customer, err := vendingMachine.acquire(context.Background(), time.Minute)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	fmt.Println("Money entered is ok")
-	i.vendingMachine.setState(i.vendingMachine.hasMoney)
-	return nil
-}
-func (i *ItemRequestedState) dispenseItem() error {
-	return fmt.Errorf("Please insert money first")
-}
+	steps := []func() error{
+		customer.requestItem,
+		func() error { return customer.insertMoney(5) },
+		func() error { return customer.insertMoney(10) },
+		customer.dispenseItem,
+		customer.requestItem,
+		func() error { return vendingMachine.addItem(2) },
+		customer.requestItem,
+		func() error { return customer.insertMoney(10) },
+		customer.dispenseItem,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			var invalid *InvalidEventError[VendingState, VendingEvent]
+			if errors.As(err, &invalid) {
+				fmt.Printf("Rejected in %s: %v\n", invalid.State, err)
+			} else {
+				fmt.Println("Error:", err)
+			}
+		}
+		fmt.Printf("state: %s, items: %d\n", vendingMachine.state(), vendingMachine.itemCount)
+	}
+	customer.release()
 
-type HasMoneyState struct {
-	vendingMachine *VendingMachine
-}
+	fmt.Println()
+	multiSlotDemo()
 
-func (i *HasMoneyState) requestItem() error {
-	return fmt.Errorf("Item dispense in progress")
-}
+	fmt.Println()
+	persistenceDemo()
 
-func (i *HasMoneyState) addItem(count int) error {
-	return fmt.Errorf("Item dispense in progress")
-}
+	fmt.Println()
+	sessionDemo()
 
-func (i *HasMoneyState) insertMoney(money int) error {
-	return fmt.Errorf("Item out of stock")
-}
-func (i *HasMoneyState) dispenseItem() error {
-	fmt.Println("Dispensing Item")
-	i.vendingMachine.itemCount = i.vendingMachine.itemCount - 1
-	if i.vendingMachine.itemCount == 0 {
-		i.vendingMachine.setState(i.vendingMachine.noItem)
-	} else {
-		i.vendingMachine.setState(i.vendingMachine.hasItem)
-	}
-	return nil
+	fmt.Println()
+	auditDemo()
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+A VendingMachine forgets its state, stock and the money inserted when the
+process stops. persistTo saves a snapshot to a file after every transition,
+and openVendingMachine restores it at startup.
+
+A snapshot is checked before it is used, since a machine restored into an
+impossible state (no items but itemCount > 0, money held while no item is
+requested) would misbehave in ways the transitions never allow.
+*/
+
+var errInconsistentSnapshot = errors.New("inconsistent vending machine snapshot")
+
+type vendingSnapshot struct {
+	State     VendingState `json:"state"`
+	ItemCount int          `json:"itemCount"`
+	ItemPrice int          `json:"itemPrice"`
+	Credit    int          `json:"credit"`
+}
+
+func (v *VendingMachine) snapshot() vendingSnapshot {
+	return vendingSnapshot{
+		State:     v.machine.State(),
+		ItemCount: v.itemCount,
+		ItemPrice: v.itemPrice,
+		Credit:    v.credit,
+	}
+}
+
+// persistTo saves the machine to path now and after every transition.
+func (v *VendingMachine) persistTo(path string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.snapshotPath = path
+	return v.save()
+}
+
+// save writes the snapshot to a temporary file first so a crash never
+// leaves a half written snapshot behind.
+func (v *VendingMachine) save() error {
+	data, err := json.MarshalIndent(v.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(v.snapshotPath), filepath.Base(v.snapshotPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("saving vending machine: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("saving vending machine: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("saving vending machine: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("saving vending machine: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), v.snapshotPath); err != nil {
+		return fmt.Errorf("saving vending machine: %w", err)
+	}
+	return nil
+}
+
+// validate rejects snapshots the transitions could never have produced.
+func (s vendingSnapshot) validate() error {
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", errInconsistentSnapshot, fmt.Sprintf(format, args...))
+	}
+	if !vendingMachineDefinition.knows(s.State) {
+		return fail("unknown state %q", s.State)
+	}
+	if s.ItemCount < 0 {
+		return fail("negative itemCount %d", s.ItemCount)
+	}
+	if s.ItemPrice < 0 {
+		return fail("negative itemPrice %d", s.ItemPrice)
+	}
+	switch s.State {
+	case NoItem:
+		if s.ItemCount > 0 {
+			return fail("state %s with itemCount %d", s.State, s.ItemCount)
+		}
+	case ItemRequested, HasMoney:
+		if s.ItemCount == 0 {
+			return fail("state %s with no items", s.State)
+		}
+	}
+	if s.State == HasMoney {
+		if s.Credit < s.ItemPrice {
+			return fail("state %s with credit %d below itemPrice %d", s.State, s.Credit, s.ItemPrice)
+		}
+	} else if s.Credit != 0 {
+		return fail("state %s with credit %d", s.State, s.Credit)
+	}
+	return nil
+}
+
+func restoreVendingMachine(path string) (*VendingMachine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s vendingSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInconsistentSnapshot, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	v := &VendingMachine{
+		itemCount:    s.ItemCount,
+		itemPrice:    s.ItemPrice,
+		credit:       s.Credit,
+		snapshotPath: path,
+		sessions:     make(chan struct{}, 1),
+	}
+	v.machine = NewMachine(vendingMachineDefinition, s.State, v)
+	return v, nil
+}
+
+// openVendingMachine restores the machine saved at path, or creates a new
+// one there if no snapshot exists yet.
+func openVendingMachine(path string, itemCount, itemPrice int) (*VendingMachine, error) {
+	v, err := restoreVendingMachine(path)
+	if errors.Is(err, os.ErrNotExist) {
+		v = newVendingMachine(itemCount, itemPrice)
+		return v, v.persistTo(path)
+	}
+	return v, err
+}
+
+func persistenceDemo() {
+	dir, err := os.MkdirTemp("", "vending")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "machine.json")
+
+	v, err := openVendingMachine(path, 2, 10)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	customer, err := v.acquire(context.Background(), time.Minute)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	customer.requestItem()
+	customer.insertMoney(15)
+	// The process dies here, before the session could end. The abandoned
+	// session would only time out after a minute, long after the demo.
+
+	// The process restarts and picks up with the money already inserted.
+	restored, err := openVendingMachine(path, 2, 10)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("restored state: %s, items: %d, credit: %d\n", restored.state(), restored.itemCount, restored.credit)
+	if customer, err := restored.acquire(context.Background(), time.Minute); err == nil {
+		customer.dispenseItem()
+		customer.release()
+	}
+
+	os.WriteFile(path, []byte(`{"state": "noItem", "itemCount": 3, "itemPrice": 10}`), 0o644)
+	if _, err := openVendingMachine(path, 2, 10); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
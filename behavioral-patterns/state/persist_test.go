@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "machine.json")
+	v, err := openVendingMachine(path, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every transition is saved, so the file follows the machine.
+	if err := v.fire(RequestItem, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.fire(InsertMoney, 15); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := openVendingMachine(path, 7, 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.snapshot(), v.snapshot(); got != want {
+		t.Fatalf("restored %+v, want %+v", got, want)
+	}
+	if err := restored.fire(DispenseItem, nil); err != nil {
+		t.Fatal(err)
+	}
+	again, err := restoreVendingMachine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := vendingSnapshot{State: HasItem, ItemCount: 1, ItemPrice: 10}
+	if got := again.snapshot(); got != want {
+		t.Errorf("after dispensing restored %+v, want %+v", got, want)
+	}
+}
+
+func TestCorruptedSnapshotIsRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", `{"state": "hasItem", "itemCount": `},
+		{"wrong type", `{"state": "hasItem", "itemCount": "two"}`},
+		{"unknown state", `{"state": "broken", "itemCount": 1, "itemPrice": 10}`},
+		{"negative stock", `{"state": "hasItem", "itemCount": -1, "itemPrice": 10}`},
+		{"negative price", `{"state": "hasItem", "itemCount": 1, "itemPrice": -10}`},
+		{"stock while out of stock", `{"state": "noItem", "itemCount": 3, "itemPrice": 10}`},
+		{"item requested without stock", `{"state": "itemRequested", "itemCount": 0, "itemPrice": 10}`},
+		{"credit below price", `{"state": "hasMoney", "itemCount": 1, "itemPrice": 10, "credit": 5}`},
+		{"credit without request", `{"state": "hasItem", "itemCount": 1, "itemPrice": 10, "credit": 5}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "machine.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := openVendingMachine(path, 2, 10); !errors.Is(err, errInconsistentSnapshot) {
+				t.Errorf("got %v, want %v", err, errInconsistentSnapshot)
+			}
+			// The bad snapshot is left alone rather than replaced.
+			if data, _ := os.ReadFile(path); string(data) != tt.data {
+				t.Errorf("snapshot was overwritten with %s", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+Several customers may use one VendingMachine from different goroutines.
+Every event is serialized by the machine's mutex, but that alone would still
+let one customer's insertMoney complete another customer's requestItem. So
+buying is only possible through a Session: a customer first acquires the
+machine, and nobody else can acquire it until the session is released.
+
+A customer who walks away would block the machine forever, so a session that
+sees no activity for its timeout is released automatically. Releasing a
+session in the middle of a purchase cancels the request and returns any money
+inserted, leaving the machine ready for the next customer.
+*/
+
+var errSessionEnded = errors.New("session ended")
+
+type Session struct {
+	machine *VendingMachine
+	timeout time.Duration
+
+	mu       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+	ended    bool
+}
+
+// acquire waits until no other customer holds the machine, or until ctx is
+// done. The session is released after timeout without activity.
+func (v *VendingMachine) acquire(ctx context.Context, timeout time.Duration) (*Session, error) {
+	select {
+	case v.sessions <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	s := &Session{machine: v, timeout: timeout, deadline: time.Now().Add(timeout)}
+	s.timer = time.AfterFunc(timeout, s.expire)
+	return s, nil
+}
+
+func (s *Session) requestItem() error {
+	return s.do(RequestItem, nil)
+}
+
+func (s *Session) insertMoney(money int) error {
+	return s.do(InsertMoney, money)
+}
+
+func (s *Session) dispenseItem() error {
+	return s.do(DispenseItem, nil)
+}
+
+func (s *Session) cancelRequest() error {
+	return s.do(CancelRequest, nil)
+}
+
+// release ends the session and hands the machine to the next customer.
+func (s *Session) release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return errSessionEnded
+	}
+	s.timer.Stop()
+	s.end()
+	return nil
+}
+
+func (s *Session) do(event VendingEvent, arg any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return errSessionEnded
+	}
+	s.deadline = time.Now().Add(s.timeout)
+	return s.machine.fire(event, arg)
+}
+
+// expire runs when the timer fires. Activity since the timer was set moves
+// the deadline, in which case the timer is set again.
+func (s *Session) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if left := time.Until(s.deadline); left > 0 {
+		s.timer.Reset(left)
+		return
+	}
+	s.end()
+}
+
+// end must be called with s.mu held.
+func (s *Session) end() {
+	s.ended = true
+	switch s.machine.state() {
+	case ItemRequested, HasMoney:
+		s.machine.fire(CancelRequest, nil)
+	}
+	<-s.machine.sessions
+}
+
+func sessionDemo() {
+	const customers, items = 6, 4
+	v := newVendingMachine(items, 10)
+
+	var mu sync.Mutex
+	bought, walkedAway := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < customers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			s, err := v.acquire(ctx, 20*time.Millisecond)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			if i == 0 {
+				// Requests an item, then leaves without paying or releasing.
+				s.requestItem()
+				mu.Lock()
+				walkedAway++
+				mu.Unlock()
+				return
+			}
+			defer s.release()
+			if err := s.requestItem(); err != nil {
+				return
+			}
+			if err := s.insertMoney(10); err != nil {
+				return
+			}
+			if s.dispenseItem() == nil {
+				mu.Lock()
+				bought++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// The customer who walked away may still hold the machine.
+	s, err := v.acquire(context.Background(), time.Second)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	s.release()
+
+	fmt.Printf("customers: %d, bought: %d, walked away: %d, left: %d, state: %s\n",
+		customers, bought, walkedAway, v.itemCount, v.state())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionsSerializeCustomers(t *testing.T) {
+	const customers, items = 20, 7
+	v := newVendingMachine(items, 10)
+
+	var mu sync.Mutex
+	bought := 0
+	var wg sync.WaitGroup
+	for i := 0; i < customers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			s, err := v.acquire(ctx, time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer s.release()
+			if err := s.requestItem(); err != nil {
+				return // sold out
+			}
+			// Nobody else can touch the machine mid-purchase.
+			if err := s.insertMoney(10); err != nil {
+				t.Errorf("insertMoney after a granted request: %v", err)
+				return
+			}
+			if err := s.dispenseItem(); err != nil {
+				t.Errorf("dispenseItem after paying: %v", err)
+				return
+			}
+			mu.Lock()
+			bought++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if bought != items || v.itemCount != 0 || v.credit != 0 || v.state() != NoItem {
+		t.Errorf("bought %d, %d left, credit %d, state %s", bought, v.itemCount, v.credit, v.state())
+	}
+}
+
+func TestSessionTimesOutMidPurchase(t *testing.T) {
+	v := newVendingMachine(3, 10)
+	s, err := v.acquire(context.Background(), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.requestItem()
+	if err := s.insertMoney(10); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next customer gets the machine once the first session expires.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	next, err := v.acquire(ctx, time.Second)
+	if err != nil {
+		t.Fatalf("the expired session still holds the machine: %v", err)
+	}
+	defer next.release()
+
+	if v.state() != HasItem || v.credit != 0 || v.itemCount != 3 {
+		t.Errorf("after expiry: state %s, credit %d, items %d", v.state(), v.credit, v.itemCount)
+	}
+	if err := s.dispenseItem(); !errors.Is(err, errSessionEnded) {
+		t.Errorf("dispenseItem on an expired session: got %v, want %v", err, errSessionEnded)
+	}
+	if err := s.release(); !errors.Is(err, errSessionEnded) {
+		t.Errorf("release of an expired session: got %v, want %v", err, errSessionEnded)
+	}
+}
+
+func TestSessionActivityExtendsDeadline(t *testing.T) {
+	v := newVendingMachine(10, 10)
+	s, err := v.acquire(context.Background(), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		time.Sleep(40 * time.Millisecond)
+		if err := s.requestItem(); err != nil {
+			t.Fatalf("after %d requests: %v", i, err)
+		}
+		if err := s.cancelRequest(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireGivesUpWithContext(t *testing.T) {
+	v := newVendingMachine(1, 10)
+	holder, err := v.acquire(context.Background(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := v.acquire(ctx, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire while held: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	holder.release()
+	s, err := v.acquire(context.Background(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.release()
+}
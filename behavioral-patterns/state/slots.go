@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+/*
+MultiSlotVendingMachine sells several products, each from its own slot with
+its own price and stock, and takes coins and notes from a fixed set of
+denominations. Money is counted in cents.
+
+A customer can insert money and then pick a slot by its code, or pick first
+and pay afterwards. Inserted money is held apart from the coin inventory until
+the sale completes, so cancel always returns exactly what was inserted. A sale
+is refused, and the credit kept, when the machine can't pay back the change
+from the coins it holds.
+*/
+
+var acceptedDenominations = []int{5, 10, 25, 100, 500}
+
+type SlotState string
+
+const (
+	Idle          SlotState = "idle"
+	HasCredit     SlotState = "hasCredit"
+	AwaitingMoney SlotState = "awaitingMoney"
+)
+
+type SlotEvent string
+
+const (
+	Insert  SlotEvent = "insert"
+	Select  SlotEvent = "select"
+	Cancel  SlotEvent = "cancel"
+	Restock SlotEvent = "restock"
+)
+
+var (
+	errUnknownSlot  = errors.New("unknown slot")
+	errSoldOut      = errors.New("sold out")
+	errNoChange     = errors.New("unable to make change, please insert the exact amount")
+	errNotAccepted  = errors.New("denomination not accepted")
+	errNotEnough    = errors.New("not enough credit")
+	errMoneyPending = errors.New("money still to be inserted")
+)
+
+type Slot struct {
+	Code    string
+	Product string
+	Price   int
+	Stock   int
+}
+
+// Sale is what the customer takes from the tray.
+type Sale struct {
+	Product string
+	Change  map[int]int
+}
+
+type restockOrder struct {
+	code  string
+	count int
+}
+
+type slotTransition = Transition[SlotState, SlotEvent, *MultiSlotVendingMachine]
+
+var multiSlotDefinition = NewDefinition[SlotState, SlotEvent, *MultiSlotVendingMachine]().
+	Allow(
+		slotTransition{From: Idle, Event: Insert, To: HasCredit, Guard: accepted, GuardName: "accepted denomination", Action: (*MultiSlotVendingMachine).credit},
+		slotTransition{From: Idle, Event: Select, To: AwaitingMoney, Guard: inStock, GuardName: "slot in stock", Action: (*MultiSlotVendingMachine).choose},
+		slotTransition{From: Idle, Event: Cancel, To: Idle, Action: (*MultiSlotVendingMachine).refund},
+		slotTransition{From: Idle, Event: Restock, To: Idle, Guard: knownSlot, GuardName: "known slot and count > 0", Action: (*MultiSlotVendingMachine).restock},
+
+		slotTransition{From: HasCredit, Event: Insert, To: HasCredit, Guard: accepted, GuardName: "accepted denomination", Action: (*MultiSlotVendingMachine).credit},
+		slotTransition{From: HasCredit, Event: Select, To: Idle, Guard: canSell, GuardName: "credit >= price and change available", Action: (*MultiSlotVendingMachine).sell},
+		slotTransition{From: HasCredit, Event: Select, To: AwaitingMoney, Guard: short, GuardName: "slot in stock and credit < price", Action: (*MultiSlotVendingMachine).choose},
+		slotTransition{From: HasCredit, Event: Cancel, To: Idle, Action: (*MultiSlotVendingMachine).refund},
+
+		slotTransition{From: AwaitingMoney, Event: Insert, To: Idle, Guard: completes, GuardName: "credit reaches price and change available", Action: (*MultiSlotVendingMachine).pay},
+		slotTransition{From: AwaitingMoney, Event: Insert, To: AwaitingMoney, Guard: stillShort, GuardName: "credit < price", Action: (*MultiSlotVendingMachine).credit},
+		slotTransition{From: AwaitingMoney, Event: Select, To: Idle, Guard: canSell, GuardName: "credit >= price and change available", Action: (*MultiSlotVendingMachine).sell},
+		slotTransition{From: AwaitingMoney, Event: Select, To: AwaitingMoney, Guard: short, GuardName: "slot in stock and credit < price", Action: (*MultiSlotVendingMachine).choose},
+		slotTransition{From: AwaitingMoney, Event: Cancel, To: Idle, Action: (*MultiSlotVendingMachine).refund},
+	).
+	Refuse(HasCredit, "Transaction in progress", Restock).
+	Refuse(AwaitingMoney, "Transaction in progress", Restock)
+
+type MultiSlotVendingMachine struct {
+	machine *Machine[SlotState, SlotEvent, *MultiSlotVendingMachine]
+
+	slots    map[string]*Slot
+	coins    map[int]int // coin inventory by denomination
+	inserted []int       // held until the sale completes or is cancelled
+	selected string
+
+	sale     *Sale
+	refunded map[int]int
+}
+
+func newMultiSlotVendingMachine(slots []Slot, coins map[int]int) *MultiSlotVendingMachine {
+	v := &MultiSlotVendingMachine{
+		slots: map[string]*Slot{},
+		coins: map[int]int{},
+	}
+	for _, s := range slots {
+		s.Code = strings.ToUpper(s.Code)
+		v.slots[s.Code] = &s
+	}
+	for denomination, count := range coins {
+		v.coins[denomination] = count
+	}
+	v.machine = NewMachine(multiSlotDefinition, Idle, v)
+	return v
+}
+
+// insertMoney takes a coin or note. It returns the sale when the money
+// completes the purchase of the selected slot.
+func (v *MultiSlotVendingMachine) insertMoney(amount int) (*Sale, error) {
+	v.sale = nil
+	err := v.machine.Fire(Insert, amount)
+	return v.sale, err
+}
+
+// selectItem picks a slot by its code, e.g. "A1", and sells it if the
+// credit is enough.
+func (v *MultiSlotVendingMachine) selectItem(code string) (*Sale, error) {
+	v.sale = nil
+	err := v.machine.Fire(Select, strings.ToUpper(code))
+	return v.sale, err
+}
+
+// cancel returns the money inserted so far and clears the selection.
+func (v *MultiSlotVendingMachine) cancel() (map[int]int, error) {
+	v.refunded = nil
+	err := v.machine.Fire(Cancel, nil)
+	return v.refunded, err
+}
+
+func (v *MultiSlotVendingMachine) restockSlot(code string, count int) error {
+	return v.machine.Fire(Restock, restockOrder{code: strings.ToUpper(code), count: count})
+}
+
+func (v *MultiSlotVendingMachine) state() SlotState {
+	return v.machine.State()
+}
+
+func (v *MultiSlotVendingMachine) balance() int {
+	total := 0
+	for _, coin := range v.inserted {
+		total += coin
+	}
+	return total
+}
+
+func (v *MultiSlotVendingMachine) slot(code string) (*Slot, error) {
+	s, ok := v.slots[code]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", errUnknownSlot, code)
+	}
+	return s, nil
+}
+
+func (v *MultiSlotVendingMachine) credit(arg any) error {
+	v.inserted = append(v.inserted, arg.(int))
+	return nil
+}
+
+func (v *MultiSlotVendingMachine) choose(arg any) error {
+	s, _ := v.slot(arg.(string))
+	v.selected = s.Code
+	fmt.Printf("%s costs %d, please insert %d more\n", s.Product, s.Price, s.Price-v.balance())
+	return nil
+}
+
+func (v *MultiSlotVendingMachine) pay(arg any) error {
+	v.credit(arg)
+	return v.sell(v.selected)
+}
+
+// sell moves the inserted money into the inventory, pays back the change and
+// dispenses the product.
+func (v *MultiSlotVendingMachine) sell(arg any) error {
+	s, _ := v.slot(arg.(string))
+	change, ok := v.change(v.balance() - s.Price)
+	if !ok {
+		return errNoChange
+	}
+	for _, coin := range v.inserted {
+		v.coins[coin]++
+	}
+	for denomination, count := range change {
+		v.coins[denomination] -= count
+	}
+	s.Stock--
+	v.inserted = nil
+	v.selected = ""
+	v.sale = &Sale{Product: s.Product, Change: change}
+	return nil
+}
+
+func (v *MultiSlotVendingMachine) refund(arg any) error {
+	v.refunded = map[int]int{}
+	for _, coin := range v.inserted {
+		v.refunded[coin]++
+	}
+	v.inserted = nil
+	v.selected = ""
+	return nil
+}
+
+func (v *MultiSlotVendingMachine) restock(arg any) error {
+	r := arg.(restockOrder)
+	v.slots[r.code].Stock += r.count
+	return nil
+}
+
+// change works out the change from the coin inventory plus the money
+// inserted for this sale.
+func (v *MultiSlotVendingMachine) change(amount int) (map[int]int, bool) {
+	available := map[int]int{}
+	for denomination, count := range v.coins {
+		available[denomination] = count
+	}
+	for _, coin := range v.inserted {
+		available[coin]++
+	}
+	return makeChange(amount, available)
+}
+
+// makeChange pays amount with as few coins as possible without using more
+// of a denomination than available. Greedy choice isn't enough once counts
+// are limited: 30 can't be paid as 25+5 without fives but can as 3x10.
+func makeChange(amount int, available map[int]int) (map[int]int, bool) {
+	if amount < 0 {
+		return nil, false
+	}
+	var denominations []int
+	for denomination, count := range available {
+		if count > 0 {
+			denominations = append(denominations, denomination)
+		}
+	}
+	sort.Ints(denominations)
+
+	const unreachable = math.MaxInt
+	best := make([]int, amount+1)
+	for a := 1; a <= amount; a++ {
+		best[a] = unreachable
+	}
+	// used[i][a] is how many coins of denominations[i] the best way to pay
+	// a with the first i+1 denominations takes.
+	used := make([][]int, len(denominations))
+	for i, d := range denominations {
+		next := make([]int, amount+1)
+		used[i] = make([]int, amount+1)
+		for a := 0; a <= amount; a++ {
+			next[a] = unreachable
+			for k := 0; k <= available[d] && k*d <= a; k++ {
+				if best[a-k*d] != unreachable && best[a-k*d]+k < next[a] {
+					next[a] = best[a-k*d] + k
+					used[i][a] = k
+				}
+			}
+		}
+		best = next
+	}
+	if best[amount] == unreachable {
+		return nil, false
+	}
+
+	change := map[int]int{}
+	for i, a := len(denominations)-1, amount; i >= 0; i-- {
+		if k := used[i][a]; k > 0 {
+			change[denominations[i]] = k
+			a -= k * denominations[i]
+		}
+	}
+	return change, true
+}
+
+func accepted(v *MultiSlotVendingMachine, arg any) error {
+	for _, denomination := range acceptedDenominations {
+		if arg.(int) == denomination {
+			return nil
+		}
+	}
+	return fmt.Errorf("%d: %w", arg.(int), errNotAccepted)
+}
+
+func knownSlot(v *MultiSlotVendingMachine, arg any) error {
+	r := arg.(restockOrder)
+	if r.count <= 0 {
+		return fmt.Errorf("restock count must be positive, got %d", r.count)
+	}
+	_, err := v.slot(r.code)
+	return err
+}
+
+func inStock(v *MultiSlotVendingMachine, arg any) error {
+	s, err := v.slot(arg.(string))
+	if err != nil {
+		return err
+	}
+	if s.Stock <= 0 {
+		return fmt.Errorf("%s: %w", s.Code, errSoldOut)
+	}
+	return nil
+}
+
+func canSell(v *MultiSlotVendingMachine, arg any) error {
+	if err := inStock(v, arg); err != nil {
+		return err
+	}
+	s, _ := v.slot(arg.(string))
+	if v.balance() < s.Price {
+		return fmt.Errorf("%w: %s costs %d, inserted %d", errNotEnough, s.Product, s.Price, v.balance())
+	}
+	if _, ok := v.change(v.balance() - s.Price); !ok {
+		return errNoChange
+	}
+	return nil
+}
+
+func short(v *MultiSlotVendingMachine, arg any) error {
+	if err := inStock(v, arg); err != nil {
+		return err
+	}
+	if s, _ := v.slot(arg.(string)); v.balance() >= s.Price {
+		return fmt.Errorf("%s is paid for", s.Product)
+	}
+	return nil
+}
+
+func completes(v *MultiSlotVendingMachine, arg any) error {
+	if err := accepted(v, arg); err != nil {
+		return err
+	}
+	s, _ := v.slot(v.selected)
+	total := v.balance() + arg.(int)
+	if total < s.Price {
+		return errMoneyPending
+	}
+	available := map[int]int{arg.(int): 1}
+	for denomination, count := range v.coins {
+		available[denomination] += count
+	}
+	for _, coin := range v.inserted {
+		available[coin]++
+	}
+	if _, ok := makeChange(total-s.Price, available); !ok {
+		return errNoChange
+	}
+	return nil
+}
+
+func stillShort(v *MultiSlotVendingMachine, arg any) error {
+	if err := accepted(v, arg); err != nil {
+		return err
+	}
+	if s, _ := v.slot(v.selected); v.balance()+arg.(int) >= s.Price {
+		return fmt.Errorf("%s is paid for", s.Product)
+	}
+	return nil
+}
+
+func multiSlotDemo() {
+	v := newMultiSlotVendingMachine([]Slot{
+		{Code: "A1", Product: "Crisps", Price: 120, Stock: 2},
+		{Code: "A2", Product: "Chocolate", Price: 95, Stock: 1},
+		{Code: "B1", Product: "Water", Price: 70, Stock: 0},
+	}, map[int]int{5: 2, 10: 3, 25: 1})
+
+	report := func(sale *Sale, err error) {
+		switch {
+		case err != nil:
+			fmt.Println("Error:", err)
+		case sale != nil:
+			fmt.Printf("Dispensed %s, change %v\n", sale.Product, sale.Change)
+		}
+		fmt.Printf("state: %s, credit: %d\n", v.state(), v.balance())
+	}
+
+	report(v.selectItem("b1"))
+	report(v.selectItem("a1"))
+	report(v.insertMoney(100))
+	report(v.insertMoney(3))
+	report(v.insertMoney(25))
+
+	report(v.insertMoney(100))
+	report(v.selectItem("A2"))
+
+	report(v.insertMoney(100))
+	report(v.selectItem("A1"))
+	report(v.insertMoney(25))
+	refund, err := v.cancel()
+	fmt.Println("refund:", refund, err)
+	fmt.Println("coins:", v.coins)
+}
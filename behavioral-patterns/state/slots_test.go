@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"reflect"
+	"testing"
+)
+
+func TestMakeChange(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    int
+		available map[int]int
+		want      map[int]int
+		ok        bool
+	}{
+		{"nothing owed", 0, map[int]int{25: 1}, map[int]int{}, true},
+		{"fewest coins", 40, map[int]int{5: 1, 10: 4, 25: 1}, map[int]int{5: 1, 10: 1, 25: 1}, true},
+		{"greedy would fail", 30, map[int]int{10: 3, 25: 1}, map[int]int{10: 3}, true},
+		{"limited count", 30, map[int]int{10: 2, 25: 1}, nil, false},
+		{"empty denominations", 5, map[int]int{5: 0, 25: 2}, nil, false},
+		{"uses the larger coins first", 100, map[int]int{25: 2, 10: 10}, map[int]int{25: 2, 10: 5}, true},
+		{"negative amount", -5, map[int]int{5: 1}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := maps.Clone(tt.available)
+			got, ok := makeChange(tt.amount, tt.available)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("makeChange(%d, %v) = %v, %v, want %v, %v", tt.amount, tt.available, got, ok, tt.want, tt.ok)
+			}
+			if !reflect.DeepEqual(tt.available, before) {
+				t.Errorf("available was modified: %v", tt.available)
+			}
+		})
+	}
+}
+
+func newTestSlotMachine(coins map[int]int) *MultiSlotVendingMachine {
+	return newMultiSlotVendingMachine([]Slot{
+		{Code: "A1", Product: "Crisps", Price: 70, Stock: 2},
+		{Code: "B1", Product: "Water", Price: 50, Stock: -1},
+	}, coins)
+}
+
+func TestExactChangeRefusal(t *testing.T) {
+	v := newTestSlotMachine(map[int]int{})
+	if _, err := v.insertMoney(100); err != nil {
+		t.Fatal(err)
+	}
+	sale, err := v.selectItem("A1")
+	if !errors.Is(err, errNoChange) || sale != nil {
+		t.Fatalf("selectItem = %v, %v, want %v", sale, err, errNoChange)
+	}
+	if v.state() != HasCredit || v.balance() != 100 {
+		t.Errorf("state %s with credit %d, want %s with 100", v.state(), v.balance(), HasCredit)
+	}
+
+	// The exact amount needs no change.
+	v.cancel()
+	v.selectItem("A1")
+	for _, coin := range []int{25, 25, 10} {
+		if sale, err := v.insertMoney(coin); err != nil || sale != nil {
+			t.Fatalf("insertMoney(%d) = %v, %v", coin, sale, err)
+		}
+	}
+	sale, err = v.insertMoney(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sale == nil || sale.Product != "Crisps" || len(sale.Change) != 0 {
+		t.Errorf("sale = %+v, want Crisps with no change", sale)
+	}
+	if want := map[int]int{10: 2, 25: 2}; !reflect.DeepEqual(v.coins, want) {
+		t.Errorf("coins = %v, want %v", v.coins, want)
+	}
+}
+
+func TestCancelReturnsInsertedCoins(t *testing.T) {
+	coins := map[int]int{5: 4, 10: 4, 25: 4}
+	tests := []struct {
+		name     string
+		selected bool
+		inserted []int
+		want     map[int]int
+	}{
+		{"with credit", false, []int{25, 100, 25, 10}, map[int]int{10: 1, 25: 2, 100: 1}},
+		{"awaiting money", true, []int{25, 25, 5}, map[int]int{5: 1, 25: 2}},
+		{"nothing inserted", false, nil, map[int]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestSlotMachine(coins)
+			if tt.selected {
+				v.selectItem("A1")
+			}
+			for _, coin := range tt.inserted {
+				if _, err := v.insertMoney(coin); err != nil {
+					t.Fatal(err)
+				}
+			}
+			refund, err := v.cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(refund, tt.want) {
+				t.Errorf("refund = %v, want %v", refund, tt.want)
+			}
+			if !reflect.DeepEqual(v.coins, coins) || v.balance() != 0 || v.state() != Idle {
+				t.Errorf("after cancel: coins %v, credit %d, state %s", v.coins, v.balance(), v.state())
+			}
+		})
+	}
+}
+
+func TestRestockAndStockGuards(t *testing.T) {
+	v := newTestSlotMachine(map[int]int{})
+	for _, count := range []int{0, -3} {
+		if err := v.restockSlot("A1", count); err == nil {
+			t.Errorf("restockSlot(A1, %d) succeeded", count)
+		}
+	}
+	if err := v.restockSlot("Z9", 1); !errors.Is(err, errUnknownSlot) {
+		t.Errorf("restockSlot(Z9) = %v, want %v", err, errUnknownSlot)
+	}
+	if got := v.slots["A1"].Stock; got != 2 {
+		t.Errorf("A1 stock = %d after refused restocks, want 2", got)
+	}
+
+	if _, err := v.selectItem("B1"); !errors.Is(err, errSoldOut) {
+		t.Errorf("selecting a slot with negative stock: got %v, want %v", err, errSoldOut)
+	}
+	if err := v.restockSlot("b1", 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.slots["B1"].Stock; got != 2 {
+		t.Errorf("B1 stock = %d, want 2", got)
+	}
+}
@@ -11,6 +11,11 @@ instead interact through the tower. You'll create the necessary
 interfaces and types for the mediator and aircraft, and simulate
 altitude change requests, observing how the tower facilitates
 communication between planes.
+
+The tower also enforces the rules of its airspace: airborne planes must keep
+a minimum vertical separation, takeoffs and landings need a runway and wait
+in a queue when none is free, and every decision is recorded on a tick-based
+timeline.
 */
 
 import (
@@ -19,33 +24,15 @@ import (
 
 type Mediator interface {
 	Notify(sender Aircraft, action string)
+	Request(sender Aircraft, request Request) Decision
 }
 
 type Aircraft interface {
 	PerformAction(action string)
 
 	GetName() string
-}
-
-type FlightControlTower struct {
-	airplanes []Aircraft
-}
-
-func NewFlightControlTower() *FlightControlTower {
-	return &FlightControlTower{}
-}
-
-func (tower *FlightControlTower) AddAircraft(aircraft Aircraft) {
-	tower.airplanes = append(tower.airplanes, aircraft)
-}
-
-func (tower *FlightControlTower) Notify(sender Aircraft, action string) {
-	for _, airplane := range tower.airplanes {
-
-		if airplane != sender {
-			airplane.PerformAction(fmt.Sprintf("%s (by %s)", action, sender.GetName()))
-		}
-	}
+	GetAltitude() int
+	SetAltitude(altitude int)
 }
 
 type Airplane struct {
@@ -62,6 +49,16 @@ func NewAirplane(name string, tower Mediator) *Airplane {
 	}
 }
 
+// NewAirborneAirplane creates an airplane that enters the tower's airspace
+// already flying at the given altitude.
+func NewAirborneAirplane(name string, tower Mediator, altitude int) *Airplane {
+	return &Airplane{
+		name:     name,
+		tower:    tower,
+		altitude: altitude,
+	}
+}
+
 func (a *Airplane) PerformAction(action string) {
 	fmt.Printf("Airplane %s is notified: %s\n", a.name, action)
 }
@@ -70,28 +67,67 @@ func (a *Airplane) GetName() string {
 	return a.name
 }
 
-func (a *Airplane) RequestAltitudeChange(newAltitude int) {
-	if newAltitude != a.altitude {
-		fmt.Printf("%s is requesting an altitude change to %d.\n", a.name, newAltitude)
-		a.altitude = newAltitude
-		a.tower.Notify(a, fmt.Sprintf("%s is changing altitude to %d", a.name, newAltitude))
-	} else {
+func (a *Airplane) GetAltitude() int {
+	return a.altitude
+}
+
+func (a *Airplane) SetAltitude(altitude int) {
+	a.altitude = altitude
+}
+
+func (a *Airplane) RequestAltitudeChange(newAltitude int) Decision {
+	if newAltitude == a.altitude {
 		fmt.Printf("%s is already at altitude %d.\n", a.name, newAltitude)
+		return Decision{Status: Granted}
 	}
+	fmt.Printf("%s is requesting an altitude change to %d.\n", a.name, newAltitude)
+	return a.report(a.tower.Request(a, Request{Kind: AltitudeChange, Altitude: newAltitude}))
 }
 
-func main() {
-	tower := NewFlightControlTower()
+func (a *Airplane) RequestTakeoff() Decision {
+	fmt.Printf("%s is requesting takeoff.\n", a.name)
+	return a.report(a.tower.Request(a, Request{Kind: Takeoff}))
+}
 
-	plane1 := NewAirplane("Plane1", tower)
-	plane2 := NewAirplane("Plane2", tower)
+func (a *Airplane) RequestLanding() Decision {
+	fmt.Printf("%s is requesting landing.\n", a.name)
+	return a.report(a.tower.Request(a, Request{Kind: Landing}))
+}
+
+func (a *Airplane) report(decision Decision) Decision {
+	fmt.Printf("Tower to %s: %s\n", a.name, decision)
+	return decision
+}
+
+func main() {
+	tower := NewFlightControlTower(TowerConfig{
+		Runways:           []string{"09L"},
+		MinSeparation:     1000,
+		DepartureAltitude: 3000,
+		RunwayOccupancy:   2,
+	})
+
+	plane1 := NewAirborneAirplane("Plane1", tower, 8000)
+	plane2 := NewAirborneAirplane("Plane2", tower, 12000)
 	plane3 := NewAirplane("Plane3", tower)
+	plane4 := NewAirplane("Plane4", tower)
 
 	tower.AddAircraft(plane1)
 	tower.AddAircraft(plane2)
 	tower.AddAircraft(plane3)
+	tower.AddAircraft(plane4)
 
 	plane1.RequestAltitudeChange(10000)
-	plane2.RequestAltitudeChange(15000)
-	plane3.RequestAltitudeChange(12000)
+	plane1.RequestAltitudeChange(11500) // too close to Plane2
+
+	plane3.RequestTakeoff()
+	plane4.RequestTakeoff() // runway busy, queued
+	plane2.RequestLanding() // queued, but lands before Plane4 departs
+
+	tower.Run(2)
+	plane3.RequestAltitudeChange(6000) // clears the departure altitude for Plane4
+	tower.Run(6)
+
+	fmt.Println()
+	fmt.Print(tower.TimelineString())
 }
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type RequestKind int
+
+const (
+	AltitudeChange RequestKind = iota
+	Takeoff
+	Landing
+)
+
+func (k RequestKind) String() string {
+	switch k {
+	case AltitudeChange:
+		return "altitude change"
+	case Takeoff:
+		return "takeoff"
+	case Landing:
+		return "landing"
+	}
+	return "unknown"
+}
+
+type Request struct {
+	Kind     RequestKind
+	Altitude int // only used by AltitudeChange
+}
+
+type DecisionStatus int
+
+const (
+	Granted DecisionStatus = iota
+	Deferred
+	Rejected
+)
+
+func (s DecisionStatus) String() string {
+	switch s {
+	case Granted:
+		return "granted"
+	case Deferred:
+		return "deferred"
+	case Rejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+type Decision struct {
+	Status DecisionStatus
+	Reason string
+}
+
+func (d Decision) String() string {
+	if d.Reason == "" {
+		return d.Status.String()
+	}
+	return fmt.Sprintf("%s: %s", d.Status, d.Reason)
+}
+
+type TowerConfig struct {
+	Runways           []string
+	MinSeparation     int // minimum vertical distance between airborne aircraft, in feet
+	DepartureAltitude int // altitude an aircraft occupies right after takeoff
+	RunwayOccupancy   int // ticks a takeoff or landing keeps the runway busy
+}
+
+// TimelineEntry records something the tower decided or observed at a tick.
+type TimelineEntry struct {
+	Tick     int
+	Aircraft string
+	Message  string
+}
+
+func (e TimelineEntry) String() string {
+	return fmt.Sprintf("t=%d %s: %s", e.Tick, e.Aircraft, e.Message)
+}
+
+type flightStatus int
+
+const (
+	onGround flightStatus = iota
+	airborne
+	onRunway  // landing
+	departing // cleared for takeoff, holds the departure altitude
+)
+
+type flight struct {
+	aircraft Aircraft
+	status   flightStatus
+}
+
+type runwayRequest struct {
+	aircraft Aircraft
+	kind     RequestKind
+}
+
+type runway struct {
+	name      string
+	operation *runwayRequest
+	freeAt    int
+}
+
+type FlightControlTower struct {
+	config   TowerConfig
+	tick     int
+	flights  map[string]*flight
+	order    []string
+	runways  []*runway
+	queue    []*runwayRequest
+	timeline []TimelineEntry
+}
+
+func NewFlightControlTower(config TowerConfig) *FlightControlTower {
+	if len(config.Runways) == 0 {
+		config.Runways = []string{"09L"}
+	}
+	if config.MinSeparation <= 0 {
+		config.MinSeparation = 1000
+	}
+	if config.DepartureAltitude <= 0 {
+		config.DepartureAltitude = 3000
+	}
+	if config.RunwayOccupancy <= 0 {
+		config.RunwayOccupancy = 2
+	}
+
+	tower := &FlightControlTower{
+		config:  config,
+		flights: make(map[string]*flight),
+	}
+	for _, name := range config.Runways {
+		tower.runways = append(tower.runways, &runway{name: name})
+	}
+	return tower
+}
+
+// AddAircraft hands an aircraft over to the tower. Aircraft with a positive
+// altitude are considered airborne, the others are parked on the ground.
+func (tower *FlightControlTower) AddAircraft(aircraft Aircraft) {
+	status := onGround
+	if aircraft.GetAltitude() > 0 {
+		status = airborne
+	}
+	tower.flights[aircraft.GetName()] = &flight{aircraft: aircraft, status: status}
+	tower.order = append(tower.order, aircraft.GetName())
+}
+
+// Notify relays a message to every other aircraft under the tower's control.
+func (tower *FlightControlTower) Notify(sender Aircraft, action string) {
+	for _, name := range tower.order {
+		airplane := tower.flights[name].aircraft
+		if airplane != sender {
+			airplane.PerformAction(fmt.Sprintf("%s (by %s)", action, sender.GetName()))
+		}
+	}
+}
+
+func (tower *FlightControlTower) Request(sender Aircraft, request Request) Decision {
+	f, ok := tower.flights[sender.GetName()]
+	if !ok {
+		return tower.decide(sender, request, Decision{Rejected, "aircraft is not under this tower's control"})
+	}
+
+	switch request.Kind {
+	case AltitudeChange:
+		return tower.requestAltitude(f, request)
+	case Takeoff, Landing:
+		return tower.requestRunway(f, request)
+	}
+	return tower.decide(sender, request, Decision{Rejected, "unknown request"})
+}
+
+func (tower *FlightControlTower) requestAltitude(f *flight, request Request) Decision {
+	if f.status != airborne {
+		return tower.decide(f.aircraft, request, Decision{Rejected, "aircraft is not airborne"})
+	}
+	if request.Altitude <= 0 {
+		return tower.decide(f.aircraft, request, Decision{Rejected, "use a landing request to descend to the ground"})
+	}
+	if conflict := tower.conflictAt(request.Altitude, f.aircraft); conflict != "" {
+		return tower.decide(f.aircraft, request, Decision{Rejected, conflict})
+	}
+
+	f.aircraft.SetAltitude(request.Altitude)
+	decision := tower.decide(f.aircraft, request, Decision{Status: Granted})
+	tower.Notify(f.aircraft, fmt.Sprintf("%s is changing altitude to %d", f.aircraft.GetName(), request.Altitude))
+	return decision
+}
+
+func (tower *FlightControlTower) requestRunway(f *flight, request Request) Decision {
+	switch {
+	case request.Kind == Takeoff && f.status != onGround:
+		return tower.decide(f.aircraft, request, Decision{Rejected, "aircraft is not on the ground"})
+	case request.Kind == Landing && f.status != airborne:
+		return tower.decide(f.aircraft, request, Decision{Rejected, "aircraft is not airborne"})
+	case tower.queued(f.aircraft):
+		return tower.decide(f.aircraft, request, Decision{Rejected, "aircraft already has a queued runway request"})
+	}
+
+	// Queue the request and serve the queue right away, so a request that can
+	// start now is granted even when others are waiting, e.g. a landing
+	// behind a departure that is held for its altitude.
+	req := &runwayRequest{aircraft: f.aircraft, kind: request.Kind}
+	tower.queue = append(tower.queue, req)
+	tower.serveQueue()
+	position := tower.position(req)
+	if position == 0 {
+		return Decision{Status: Granted}
+	}
+
+	reason := tower.blocked(req)
+	if reason == "" {
+		reason = fmt.Sprintf("no runway available, position %d in queue", position)
+	}
+	return tower.decide(f.aircraft, request, Decision{Deferred, reason})
+}
+
+// Tick advances the simulation by one step: runway operations that are done
+// are completed and queued requests are served on the freed runways.
+func (tower *FlightControlTower) Tick() {
+	tower.tick++
+
+	for _, r := range tower.runways {
+		if r.operation != nil && tower.tick >= r.freeAt {
+			tower.complete(r)
+		}
+	}
+	tower.serveQueue()
+}
+
+// Run advances the simulation by n ticks.
+func (tower *FlightControlTower) Run(n int) {
+	for i := 0; i < n; i++ {
+		tower.Tick()
+	}
+}
+
+func (tower *FlightControlTower) CurrentTick() int {
+	return tower.tick
+}
+
+func (tower *FlightControlTower) Timeline() []TimelineEntry {
+	return append([]TimelineEntry(nil), tower.timeline...)
+}
+
+func (tower *FlightControlTower) TimelineString() string {
+	var b strings.Builder
+	for _, entry := range tower.timeline {
+		b.WriteString(entry.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// serveQueue assigns free runways to queued requests. Landings go first
+// since an aircraft in the air can't wait forever; takeoffs keep their
+// arrival order.
+func (tower *FlightControlTower) serveQueue() {
+	for {
+		r := tower.freeRunway()
+		if r == nil {
+			return
+		}
+		i := tower.next()
+		if i < 0 {
+			return
+		}
+		req := tower.queue[i]
+		tower.queue = append(tower.queue[:i], tower.queue[i+1:]...)
+		tower.start(r, req)
+	}
+}
+
+func (tower *FlightControlTower) next() int {
+	for i, req := range tower.queue {
+		if req.kind == Landing {
+			return i
+		}
+	}
+	for i, req := range tower.queue {
+		if tower.blocked(req) == "" {
+			return i
+		}
+	}
+	return -1
+}
+
+func (tower *FlightControlTower) start(r *runway, req *runwayRequest) {
+	r.operation = req
+	r.freeAt = tower.tick + tower.config.RunwayOccupancy
+	status := onRunway
+	if req.kind == Takeoff {
+		status = departing
+	}
+	tower.flights[req.aircraft.GetName()].status = status
+
+	tower.log(req.aircraft, fmt.Sprintf("%s granted on runway %s", req.kind, r.name))
+	tower.Notify(req.aircraft, fmt.Sprintf("runway %s in use for %s", r.name, req.kind))
+}
+
+func (tower *FlightControlTower) complete(r *runway) {
+	req := r.operation
+	r.operation = nil
+	f := tower.flights[req.aircraft.GetName()]
+
+	if req.kind == Takeoff {
+		// Someone may have been handed over at the departure altitude
+		// since the takeoff was granted; keep the runway until it is clear.
+		if conflict := tower.conflictAt(tower.config.DepartureAltitude, req.aircraft); conflict != "" {
+			r.operation = req
+			r.freeAt = tower.tick + 1
+			tower.log(req.aircraft, fmt.Sprintf("takeoff held on runway %s: %s", r.name, conflict))
+			return
+		}
+		f.status = airborne
+		req.aircraft.SetAltitude(tower.config.DepartureAltitude)
+		tower.log(req.aircraft, fmt.Sprintf("airborne at %d", tower.config.DepartureAltitude))
+	} else {
+		f.status = onGround
+		req.aircraft.SetAltitude(0)
+		tower.log(req.aircraft, fmt.Sprintf("landed on runway %s", r.name))
+	}
+	tower.Notify(req.aircraft, fmt.Sprintf("runway %s is clear", r.name))
+}
+
+// blocked explains why a queued request can't start yet even if a runway is
+// free. Departures wait while the departure altitude is occupied.
+func (tower *FlightControlTower) blocked(req *runwayRequest) string {
+	if req.kind != Takeoff {
+		return ""
+	}
+	for _, r := range tower.runways {
+		if r.operation != nil && r.operation.kind == Takeoff {
+			return fmt.Sprintf("%s is still departing from runway %s", r.operation.aircraft.GetName(), r.name)
+		}
+	}
+	if conflict := tower.conflictAt(tower.config.DepartureAltitude, req.aircraft); conflict != "" {
+		return "departure altitude not clear: " + conflict
+	}
+	return ""
+}
+
+// conflictAt reports the first aircraft closer than the minimum vertical
+// separation to the given altitude. A departure counts as holding the
+// departure altitude from the moment it is cleared for takeoff.
+func (tower *FlightControlTower) conflictAt(altitude int, exclude Aircraft) string {
+	for _, name := range tower.order {
+		f := tower.flights[name]
+		if f.aircraft == exclude {
+			continue
+		}
+		var held int
+		var where string
+		switch f.status {
+		case airborne:
+			held = f.aircraft.GetAltitude()
+			where = fmt.Sprintf("at %d", held)
+		case departing:
+			held = tower.config.DepartureAltitude
+			where = fmt.Sprintf("departing to %d", held)
+		default:
+			continue
+		}
+		distance := held - altitude
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance < tower.config.MinSeparation {
+			return fmt.Sprintf("%s %s is within %d ft", name, where, tower.config.MinSeparation)
+		}
+	}
+	return ""
+}
+
+func (tower *FlightControlTower) freeRunway() *runway {
+	for _, r := range tower.runways {
+		if r.operation == nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// position is the request's 1-based place in the queue, or 0 when it is not
+// queued.
+func (tower *FlightControlTower) position(req *runwayRequest) int {
+	for i, queued := range tower.queue {
+		if queued == req {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (tower *FlightControlTower) queued(aircraft Aircraft) bool {
+	for _, req := range tower.queue {
+		if req.aircraft == aircraft {
+			return true
+		}
+	}
+	return false
+}
+
+func (tower *FlightControlTower) decide(aircraft Aircraft, request Request, decision Decision) Decision {
+	message := request.Kind.String()
+	if request.Kind == AltitudeChange {
+		message = fmt.Sprintf("%s to %d", message, request.Altitude)
+	}
+	tower.log(aircraft, fmt.Sprintf("%s %s", message, decision))
+	return decision
+}
+
+func (tower *FlightControlTower) log(aircraft Aircraft, message string) {
+	tower.timeline = append(tower.timeline, TimelineEntry{
+		Tick:     tower.tick,
+		Aircraft: aircraft.GetName(),
+		Message:  message,
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTowerTimeline(t *testing.T) {
+	config := TowerConfig{
+		Runways:           []string{"09L"},
+		MinSeparation:     1000,
+		DepartureAltitude: 3000,
+		RunwayOccupancy:   2,
+	}
+
+	tests := []struct {
+		name     string
+		aircraft map[string]int // name -> altitude, 0 is on the ground
+		order    []string
+		scenario func(tower *FlightControlTower, planes map[string]*Airplane)
+		want     []TimelineEntry
+	}{
+		{
+			name:     "altitude changes keep separation",
+			aircraft: map[string]int{"Plane1": 8000, "Plane2": 12000},
+			order:    []string{"Plane1", "Plane2"},
+			scenario: func(tower *FlightControlTower, p map[string]*Airplane) {
+				p["Plane1"].RequestAltitudeChange(10000)
+				p["Plane1"].RequestAltitudeChange(11500)
+				p["Plane2"].RequestAltitudeChange(0)
+			},
+			want: []TimelineEntry{
+				{0, "Plane1", "altitude change to 10000 granted"},
+				{0, "Plane1", "altitude change to 11500 rejected: Plane2 at 12000 is within 1000 ft"},
+				{0, "Plane2", "altitude change to 0 rejected: use a landing request to descend to the ground"},
+			},
+		},
+		{
+			name:     "landings go before queued takeoffs",
+			aircraft: map[string]int{"Plane1": 8000, "Plane2": 12000, "Plane3": 0, "Plane4": 0},
+			order:    []string{"Plane1", "Plane2", "Plane3", "Plane4"},
+			scenario: func(tower *FlightControlTower, p map[string]*Airplane) {
+				p["Plane3"].RequestTakeoff()
+				p["Plane4"].RequestTakeoff()
+				p["Plane2"].RequestLanding()
+				tower.Run(2)
+				p["Plane3"].RequestAltitudeChange(6000)
+				tower.Run(4)
+			},
+			want: []TimelineEntry{
+				{0, "Plane3", "takeoff granted on runway 09L"},
+				{0, "Plane4", "takeoff deferred: Plane3 is still departing from runway 09L"},
+				{0, "Plane2", "landing deferred: no runway available, position 2 in queue"},
+				{2, "Plane3", "airborne at 3000"},
+				{2, "Plane2", "landing granted on runway 09L"},
+				{2, "Plane3", "altitude change to 6000 granted"},
+				{4, "Plane2", "landed on runway 09L"},
+				{4, "Plane4", "takeoff granted on runway 09L"},
+				{6, "Plane4", "airborne at 3000"},
+			},
+		},
+		{
+			name:     "landing behind a held departure uses the free runway",
+			aircraft: map[string]int{"Plane1": 3500, "Plane2": 0, "Plane3": 9000},
+			order:    []string{"Plane1", "Plane2", "Plane3"},
+			scenario: func(tower *FlightControlTower, p map[string]*Airplane) {
+				p["Plane2"].RequestTakeoff()
+				p["Plane3"].RequestLanding()
+				tower.Run(2)
+				p["Plane1"].RequestAltitudeChange(7000)
+				tower.Run(1)
+			},
+			want: []TimelineEntry{
+				{0, "Plane2", "takeoff deferred: departure altitude not clear: Plane1 at 3500 is within 1000 ft"},
+				{0, "Plane3", "landing granted on runway 09L"},
+				{2, "Plane3", "landed on runway 09L"},
+				{2, "Plane1", "altitude change to 7000 granted"},
+				{3, "Plane2", "takeoff granted on runway 09L"},
+			},
+		},
+		{
+			name:     "a cleared departure holds the departure altitude",
+			aircraft: map[string]int{"PlaneA": 5000, "PlaneB": 0},
+			order:    []string{"PlaneA", "PlaneB"},
+			scenario: func(tower *FlightControlTower, p map[string]*Airplane) {
+				p["PlaneB"].RequestTakeoff()
+				p["PlaneA"].RequestAltitudeChange(3000)
+				tower.Run(2)
+				p["PlaneA"].RequestAltitudeChange(3500)
+			},
+			want: []TimelineEntry{
+				{0, "PlaneB", "takeoff granted on runway 09L"},
+				{0, "PlaneA", "altitude change to 3000 rejected: PlaneB departing to 3000 is within 1000 ft"},
+				{2, "PlaneB", "airborne at 3000"},
+				{2, "PlaneA", "altitude change to 3500 rejected: PlaneB at 3000 is within 1000 ft"},
+			},
+		},
+		{
+			name:     "a takeoff is held when separation is lost before it lifts off",
+			aircraft: map[string]int{"Plane1": 0},
+			order:    []string{"Plane1"},
+			scenario: func(tower *FlightControlTower, p map[string]*Airplane) {
+				p["Plane1"].RequestTakeoff()
+				handover := NewAirborneAirplane("Plane2", tower, 3000)
+				tower.AddAircraft(handover)
+				tower.Run(2)
+				handover.RequestAltitudeChange(6000)
+				tower.Run(1)
+			},
+			want: []TimelineEntry{
+				{0, "Plane1", "takeoff granted on runway 09L"},
+				{2, "Plane1", "takeoff held on runway 09L: Plane2 at 3000 is within 1000 ft"},
+				{2, "Plane2", "altitude change to 6000 granted"},
+				{3, "Plane1", "airborne at 3000"},
+			},
+		},
+		{
+			name:     "invalid requests are rejected",
+			aircraft: map[string]int{"Plane1": 5000, "Plane2": 0},
+			order:    []string{"Plane1", "Plane2"},
+			scenario: func(tower *FlightControlTower, p map[string]*Airplane) {
+				p["Plane1"].RequestTakeoff()
+				p["Plane2"].RequestLanding()
+				p["Plane2"].RequestAltitudeChange(4000)
+			},
+			want: []TimelineEntry{
+				{0, "Plane1", "takeoff rejected: aircraft is not on the ground"},
+				{0, "Plane2", "landing rejected: aircraft is not airborne"},
+				{0, "Plane2", "altitude change to 4000 rejected: aircraft is not airborne"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tower := NewFlightControlTower(config)
+			planes := map[string]*Airplane{}
+			for _, name := range tt.order {
+				planes[name] = NewAirborneAirplane(name, tower, tt.aircraft[name])
+				tower.AddAircraft(planes[name])
+			}
+			tt.scenario(tower, planes)
+
+			if got := tower.Timeline(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("timeline:\n%s\nwant:\n%s", entries(got), entries(tt.want))
+			}
+		})
+	}
+}
+
+func TestTowerTick(t *testing.T) {
+	tower := NewFlightControlTower(TowerConfig{})
+	tower.Run(3)
+	if got := tower.CurrentTick(); got != 3 {
+		t.Errorf("CurrentTick() = %d, want 3", got)
+	}
+}
+
+func entries(timeline []TimelineEntry) string {
+	var s string
+	for _, e := range timeline {
+		s += "\t" + e.String() + "\n"
+	}
+	return s
+}
@@ -1,6 +1,8 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+)
 
 /*
 Imagine you’re developing a text editor that needs
@@ -13,6 +15,10 @@ Caretaker to handle the undo functionality by saving
 and restoring text states. Ensure the editor can save
 its state, modify the text, and undo changes, printing
 the text after each operation.
+
+The caretaker delegates storage to a SnapshotStrategy, so long documents can
+keep their history as deltas instead of full copies. Run
+go test -bench Snapshots to compare both strategies.
 */
 
 type Memento struct {
@@ -40,12 +46,27 @@ func (e *TextEditor) Restore(m Memento) {
 }
 
 type Caretaker struct {
-	undoStack [](*Memento)
+	history   SnapshotStrategy
+	undoStack []int
 	editor    *TextEditor
 }
 
+// NewCaretaker keeps the editor's history in the given strategy, or in full
+// snapshots when it is nil. &Caretaker{editor: editor} works as well and
+// keeps full snapshots.
+func NewCaretaker(editor *TextEditor, history SnapshotStrategy) *Caretaker {
+	return &Caretaker{editor: editor, history: history}
+}
+
+func (c *Caretaker) snapshots() SnapshotStrategy {
+	if c.history == nil {
+		c.history = NewFullSnapshots()
+	}
+	return c.history
+}
+
 func (c *Caretaker) Save() {
-	c.undoStack = append(c.undoStack, c.editor.CreateMemento())
+	c.undoStack = append(c.undoStack, c.snapshots().Save(c.editor.CreateMemento()))
 }
 
 func (c *Caretaker) Undo() {
@@ -54,14 +75,18 @@ func (c *Caretaker) Undo() {
 		return
 	}
 
-	memento := c.undoStack[len(c.undoStack)-1]
+	memento, err := c.snapshots().Load(c.undoStack[len(c.undoStack)-1])
+	if err != nil {
+		fmt.Println("Cannot undo:", err)
+		return
+	}
 	c.editor.Restore(*memento)
 	c.undoStack = c.undoStack[:len(c.undoStack)-1]
 }
 
 func main() {
 	editor := &TextEditor{}
-	caretaker := &Caretaker{editor: editor}
+	caretaker := NewCaretaker(editor, NewDeltaSnapshots(10))
 
 	// Initial text
 	editor.SetText("Hello")
@@ -74,4 +99,23 @@ func main() {
 
 	caretaker.Undo()
 	fmt.Println("Text after undo:", editor.GetText())
+
+	// With an undo tree, editing after an undo keeps the old branch
+	fmt.Println()
+	tree := NewUndoTree(editor, nil)
+	tree.Checkpoint("before refactor")
+
+	editor.SetText("Hello, World!")
+	tree.Commit()
+	tree.Undo()
+
+	editor.SetText("Hello, Gophers!")
+	tree.Commit()
+	editor.SetText("Hello, Gophers! Bye.")
+	tree.Commit()
+
+	tree.GoTo("before refactor")
+	tree.RedoBranch(0)
+	fmt.Println("Text on the first branch:", editor.GetText())
+	fmt.Print(tree.Render())
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+/*
+Storing the full text in every memento is simple, but a long document edited
+one character at a time keeps thousands of nearly identical copies around.
+
+DeltaSnapshots only stores what changed between consecutive versions. Every
+KeyframeInterval versions it stores the full text again, so rebuilding a
+version never has to replay more than KeyframeInterval-1 deltas.
+*/
+
+type SnapshotStrategy interface {
+	// Save stores the memento and returns its version number.
+	Save(m *Memento) int
+	// Load rebuilds the memento stored as the given version.
+	Load(version int) (*Memento, error)
+	Len() int
+	// MemoryUsage estimates the bytes held by the stored snapshots.
+	MemoryUsage() int
+}
+
+// FullSnapshots keeps a complete copy of the text for every version.
+type FullSnapshots struct {
+	versions []string
+}
+
+func NewFullSnapshots() *FullSnapshots {
+	return &FullSnapshots{}
+}
+
+func (s *FullSnapshots) Save(m *Memento) int {
+	s.versions = append(s.versions, m.text)
+	return len(s.versions) - 1
+}
+
+func (s *FullSnapshots) Load(version int) (*Memento, error) {
+	if version < 0 || version >= len(s.versions) {
+		return nil, fmt.Errorf("version %d does not exist", version)
+	}
+	return &Memento{text: s.versions[version]}, nil
+}
+
+func (s *FullSnapshots) Len() int {
+	return len(s.versions)
+}
+
+func (s *FullSnapshots) MemoryUsage() int {
+	total := cap(s.versions) * int(unsafe.Sizeof(""))
+	for _, text := range s.versions {
+		total += len(text)
+	}
+	return total
+}
+
+// Delta describes a version relative to the previous one: keep the first
+// Prefix bytes and the last Suffix bytes, and put Insert in between. Insert
+// is a copy, so a delta never keeps the version it was taken from alive.
+type Delta struct {
+	Prefix int
+	Suffix int
+	Insert string
+}
+
+func Diff(from, to string) Delta {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix &&
+		from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	return Delta{Prefix: prefix, Suffix: suffix, Insert: strings.Clone(to[prefix : len(to)-suffix])}
+}
+
+func (d Delta) Apply(from string) (string, error) {
+	if d.Prefix+d.Suffix > len(from) {
+		return "", fmt.Errorf("delta does not apply to a text of %d bytes", len(from))
+	}
+	return from[:d.Prefix] + d.Insert + from[len(from)-d.Suffix:], nil
+}
+
+type deltaEntry struct {
+	keyframe bool
+	text     string // only set on keyframes
+	delta    Delta
+}
+
+// DeltaSnapshots stores diffs between consecutive versions with a full
+// keyframe every KeyframeInterval versions.
+type DeltaSnapshots struct {
+	keyframeInterval int
+	entries          []deltaEntry
+	last             string
+}
+
+func NewDeltaSnapshots(keyframeInterval int) *DeltaSnapshots {
+	if keyframeInterval < 1 {
+		keyframeInterval = 1
+	}
+	return &DeltaSnapshots{keyframeInterval: keyframeInterval}
+}
+
+func (s *DeltaSnapshots) Save(m *Memento) int {
+	version := len(s.entries)
+	if version%s.keyframeInterval == 0 {
+		s.entries = append(s.entries, deltaEntry{keyframe: true, text: m.text})
+	} else {
+		s.entries = append(s.entries, deltaEntry{delta: Diff(s.last, m.text)})
+	}
+	s.last = m.text
+	return version
+}
+
+func (s *DeltaSnapshots) Load(version int) (*Memento, error) {
+	if version < 0 || version >= len(s.entries) {
+		return nil, fmt.Errorf("version %d does not exist", version)
+	}
+
+	keyframe := version - version%s.keyframeInterval
+	text := s.entries[keyframe].text
+	for v := keyframe + 1; v <= version; v++ {
+		var err error
+		if text, err = s.entries[v].delta.Apply(text); err != nil {
+			return nil, fmt.Errorf("rebuilding version %d: %w", version, err)
+		}
+	}
+	return &Memento{text: text}, nil
+}
+
+func (s *DeltaSnapshots) Len() int {
+	return len(s.entries)
+}
+
+// MemoryUsage counts the entries and the text they own. The latest version
+// is kept to diff the next save against, so it counts as well.
+func (s *DeltaSnapshots) MemoryUsage() int {
+	total := cap(s.entries)*int(unsafe.Sizeof(deltaEntry{})) + len(s.last)
+	for _, entry := range s.entries {
+		total += len(entry.text) + len(entry.delta.Insert)
+	}
+	return total
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const edits = 2000
+
+var document = strings.Repeat("All work and no play makes Jack a dull boy. ", 500)
+
+// typeDocument inserts a word at a different place in a long document for
+// every edit and saves after each one.
+func typeDocument(history SnapshotStrategy, edits int) {
+	editor := &TextEditor{text: document}
+	for e := 0; e < edits; e++ {
+		at := (e * 7919) % len(editor.text)
+		editor.SetText(editor.text[:at] + "word " + editor.text[at:])
+		history.Save(editor.CreateMemento())
+	}
+}
+
+// retained measures the heap the history built by fill keeps alive after a
+// garbage collection.
+func retained(fill func() SnapshotStrategy) (history SnapshotStrategy, bytes int) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	history = fill()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(history)
+	return history, int(after.HeapAlloc) - int(before.HeapAlloc)
+}
+
+func benchmarkSnapshots(b *testing.B, strategy func() SnapshotStrategy) {
+	b.Run("save", func(b *testing.B) {
+		var live int
+		for i := 0; i < b.N; i++ {
+			_, live = retained(func() SnapshotStrategy {
+				history := strategy()
+				typeDocument(history, edits)
+				return history
+			})
+		}
+		b.ReportMetric(float64(live)/1024, "KiB-live/history")
+	})
+
+	b.Run("load", func(b *testing.B) {
+		history := strategy()
+		typeDocument(history, edits)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := history.Load(i % edits); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(history.MemoryUsage())/1024, "KiB/history")
+	})
+}
+
+func BenchmarkFullSnapshots(b *testing.B) {
+	benchmarkSnapshots(b, func() SnapshotStrategy { return NewFullSnapshots() })
+}
+
+func BenchmarkDeltaSnapshots(b *testing.B) {
+	benchmarkSnapshots(b, func() SnapshotStrategy { return NewDeltaSnapshots(50) })
+}
+
+func TestDeltaSnapshotsMatchFullCopies(t *testing.T) {
+	for _, interval := range []int{1, 7, 50} {
+		full, deltas := NewFullSnapshots(), NewDeltaSnapshots(interval)
+		typeDocument(full, 300)
+		typeDocument(deltas, 300)
+
+		if deltas.Len() != full.Len() {
+			t.Fatalf("interval %d: %d deltas, %d full copies", interval, deltas.Len(), full.Len())
+		}
+		for v := 0; v < full.Len(); v++ {
+			want, err := full.Load(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := deltas.Load(v)
+			if err != nil {
+				t.Fatalf("interval %d: Load(%d): %v", interval, v, err)
+			}
+			if got.text != want.text {
+				t.Fatalf("interval %d: Load(%d) differs from the full copy", interval, v)
+			}
+		}
+		if _, err := deltas.Load(full.Len()); err == nil {
+			t.Errorf("interval %d: Load past the end succeeded", interval)
+		}
+	}
+}
+
+// MemoryUsage only counts what a strategy means to keep; the heap shows what
+// it really keeps, e.g. a delta that is a substring of the whole version.
+func TestDeltaSnapshotsRetainOnlyDeltas(t *testing.T) {
+	const edits = 500
+	full, fullLive := retained(func() SnapshotStrategy {
+		history := NewFullSnapshots()
+		typeDocument(history, edits)
+		return history
+	})
+	deltas, deltaLive := retained(func() SnapshotStrategy {
+		history := NewDeltaSnapshots(50)
+		typeDocument(history, edits)
+		return history
+	})
+
+	if limit := 2*deltas.MemoryUsage() + 256<<10; deltaLive > limit {
+		t.Errorf("delta history keeps %d KiB alive, reports %d KiB", deltaLive>>10, deltas.MemoryUsage()>>10)
+	}
+	if deltaLive*10 > fullLive {
+		t.Errorf("delta history keeps %d KiB alive, full copies %d KiB", deltaLive>>10, fullLive>>10)
+	}
+	runtime.KeepAlive(full)
+}
+
+func TestZeroCaretakerKeepsFullSnapshots(t *testing.T) {
+	editor := &TextEditor{text: "Hello"}
+	caretaker := &Caretaker{editor: editor}
+	caretaker.Save()
+	editor.SetText("Hello, World!")
+	caretaker.Undo()
+	if got := editor.GetText(); got != "Hello" {
+		t.Errorf("text after undo = %q, want %q", got, "Hello")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+The Caretaker's undo stack is linear: undoing and then editing throws the
+undone states away. UndoTree keeps every state instead. Editing after an undo
+starts a new branch next to the old one, so any earlier state, on any branch,
+can still be reached. States can also be given checkpoint names such as
+"before refactor".
+*/
+
+type undoNode struct {
+	id         int
+	version    int
+	parent     *undoNode
+	children   []*undoNode
+	redoChild  *undoNode // child Redo moves to: the one visited last
+	checkpoint string
+}
+
+type UndoTree struct {
+	editor      *TextEditor
+	history     SnapshotStrategy
+	root        *undoNode
+	current     *undoNode
+	nodes       []*undoNode
+	checkpoints map[string]*undoNode
+}
+
+// NewUndoTree starts the tree at the editor's current text. Snapshots are
+// kept in the given strategy, or in full snapshots when it is nil.
+func NewUndoTree(editor *TextEditor, history SnapshotStrategy) *UndoTree {
+	if history == nil {
+		history = NewFullSnapshots()
+	}
+	t := &UndoTree{
+		editor:      editor,
+		history:     history,
+		checkpoints: make(map[string]*undoNode),
+	}
+	t.root = t.newNode(nil)
+	t.current = t.root
+	return t
+}
+
+func (t *UndoTree) newNode(parent *undoNode) *undoNode {
+	node := &undoNode{
+		id:      len(t.nodes),
+		version: t.history.Save(t.editor.CreateMemento()),
+		parent:  parent,
+	}
+	t.nodes = append(t.nodes, node)
+	if parent != nil {
+		parent.children = append(parent.children, node)
+		parent.redoChild = node
+	}
+	return node
+}
+
+// Commit records the editor's text as a child of the current state. After an
+// undo this starts a new branch; the previous one is kept.
+func (t *UndoTree) Commit() {
+	t.current = t.newNode(t.current)
+}
+
+func (t *UndoTree) Undo() error {
+	if t.current.parent == nil {
+		return fmt.Errorf("nothing to undo")
+	}
+	t.current.parent.redoChild = t.current
+	return t.moveTo(t.current.parent)
+}
+
+// Redo follows the branch that was visited last.
+func (t *UndoTree) Redo() error {
+	if t.current.redoChild == nil {
+		return fmt.Errorf("nothing to redo")
+	}
+	return t.moveTo(t.current.redoChild)
+}
+
+// RedoBranch redoes into the n-th child of the current state, oldest first.
+func (t *UndoTree) RedoBranch(n int) error {
+	if n < 0 || n >= len(t.current.children) {
+		return fmt.Errorf("state %d has no branch %d", t.current.id, n)
+	}
+	return t.moveTo(t.current.children[n])
+}
+
+func (t *UndoTree) Checkpoint(name string) error {
+	if name == "" {
+		return fmt.Errorf("checkpoint name must not be empty")
+	}
+	if _, ok := t.checkpoints[name]; ok {
+		return fmt.Errorf("checkpoint %q already exists", name)
+	}
+	if t.current.checkpoint != "" {
+		delete(t.checkpoints, t.current.checkpoint)
+	}
+	t.current.checkpoint = name
+	t.checkpoints[name] = t.current
+	return nil
+}
+
+// GoTo jumps to a named checkpoint, wherever it is in the tree.
+func (t *UndoTree) GoTo(name string) error {
+	node, ok := t.checkpoints[name]
+	if !ok {
+		return fmt.Errorf("no checkpoint named %q", name)
+	}
+	return t.moveTo(node)
+}
+
+// Checkout jumps to the state with the given id, as shown by Render.
+func (t *UndoTree) Checkout(id int) error {
+	if id < 0 || id >= len(t.nodes) {
+		return fmt.Errorf("no state %d", id)
+	}
+	return t.moveTo(t.nodes[id])
+}
+
+// moveTo restores the node's text and makes the path to it the one Redo
+// follows from the root.
+func (t *UndoTree) moveTo(node *undoNode) error {
+	memento, err := t.history.Load(node.version)
+	if err != nil {
+		return err
+	}
+	t.editor.Restore(*memento)
+	for n := node; n.parent != nil; n = n.parent {
+		n.parent.redoChild = n
+	}
+	t.current = node
+	return nil
+}
+
+// Render draws the history as a tree, marking checkpoints and the current
+// state.
+func (t *UndoTree) Render() string {
+	var b strings.Builder
+	t.render(&b, t.root, "", "")
+	return b.String()
+}
+
+func (t *UndoTree) render(b *strings.Builder, node *undoNode, prefix, childPrefix string) {
+	text := "?"
+	if memento, err := t.history.Load(node.version); err == nil {
+		text = memento.text
+	}
+	if len(text) > 24 {
+		text = text[:21] + "..."
+	}
+
+	fmt.Fprintf(b, "%s%d %q", prefix, node.id, text)
+	if node.checkpoint != "" {
+		fmt.Fprintf(b, " [%s]", node.checkpoint)
+	}
+	if node == t.current {
+		b.WriteString(" <- current")
+	}
+	b.WriteString("\n")
+
+	for i, child := range node.children {
+		if i == len(node.children)-1 {
+			t.render(b, child, childPrefix+"└── ", childPrefix+"    ")
+		} else {
+			t.render(b, child, childPrefix+"├── ", childPrefix+"│   ")
+		}
+	}
+}
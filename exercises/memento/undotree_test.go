@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var strategies = map[string]func() SnapshotStrategy{
+	"full":  func() SnapshotStrategy { return NewFullSnapshots() },
+	"delta": func() SnapshotStrategy { return NewDeltaSnapshots(2) },
+}
+
+// newTestTree builds
+//
+//	0 "" ── 1 "a" ── 2 "ab" ─┬── 3 "abc"
+//	                         └── 4 "abd" <- current
+func newTestTree(t *testing.T, history SnapshotStrategy) (*UndoTree, *TextEditor) {
+	t.Helper()
+	editor := &TextEditor{}
+	tree := NewUndoTree(editor, history)
+	for _, text := range []string{"a", "ab", "abc"} {
+		editor.SetText(text)
+		tree.Commit()
+	}
+	if err := tree.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	editor.SetText("abd")
+	tree.Commit()
+	return tree, editor
+}
+
+func TestUndoTree(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps func(tree *UndoTree) error
+		want  string
+	}{
+		{
+			name:  "editing after undo starts a branch",
+			steps: func(tree *UndoTree) error { return nil },
+			want:  "abd",
+		},
+		{
+			name: "the undone branch is kept",
+			steps: func(tree *UndoTree) error {
+				if err := tree.Undo(); err != nil {
+					return err
+				}
+				return tree.RedoBranch(0)
+			},
+			want: "abc",
+		},
+		{
+			name: "redo follows the branch visited last",
+			steps: func(tree *UndoTree) error {
+				for _, step := range []func() error{tree.Undo, tree.Undo, tree.Redo, tree.Redo} {
+					if err := step(); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			want: "abd",
+		},
+		{
+			name: "redo follows a branch chosen with RedoBranch",
+			steps: func(tree *UndoTree) error {
+				for _, step := range []func() error{tree.Undo, func() error { return tree.RedoBranch(0) }, tree.Undo, tree.Undo, tree.Redo, tree.Redo} {
+					if err := step(); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			want: "abc",
+		},
+		{
+			name: "checking out a node on another branch",
+			steps: func(tree *UndoTree) error {
+				return tree.Checkout(3)
+			},
+			want: "abc",
+		},
+		{
+			name: "redo follows the path to the last checked out node",
+			steps: func(tree *UndoTree) error {
+				for _, step := range []func() error{func() error { return tree.Checkout(3) }, func() error { return tree.Checkout(0) }, tree.Redo, tree.Redo, tree.Redo} {
+					if err := step(); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			want: "abc",
+		},
+		{
+			name: "checkpoints can be reached from any branch",
+			steps: func(tree *UndoTree) error {
+				if err := tree.Checkout(1); err != nil {
+					return err
+				}
+				if err := tree.Checkpoint("first"); err != nil {
+					return err
+				}
+				if err := tree.Checkout(3); err != nil {
+					return err
+				}
+				return tree.GoTo("first")
+			},
+			want: "a",
+		},
+	}
+	for name, strategy := range strategies {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				tree, editor := newTestTree(t, strategy())
+				if err := tt.steps(tree); err != nil {
+					t.Fatal(err)
+				}
+				if got := editor.GetText(); got != tt.want {
+					t.Errorf("text %q, want %q\n%s", got, tt.want, tree.Render())
+				}
+			})
+		}
+	}
+}
+
+func TestUndoTreeErrors(t *testing.T) {
+	tree, editor := newTestTree(t, NewFullSnapshots())
+	tree.Checkpoint("tip")
+	for name, err := range map[string]error{
+		"redo past the tip":      tree.Redo(),
+		"redo a missing branch":  tree.RedoBranch(0),
+		"check out a wrong id":   tree.Checkout(5),
+		"go to an unknown point": tree.GoTo("nowhere"),
+		"reuse a checkpoint":     tree.Checkpoint("tip"),
+		"empty checkpoint name":  tree.Checkpoint(""),
+	} {
+		if err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if got := editor.GetText(); got != "abd" {
+		t.Errorf("failed moves changed the text to %q", got)
+	}
+
+	tree.Checkout(0)
+	if err := tree.Undo(); err == nil {
+		t.Error("undo past the root: no error")
+	}
+}
+
+func TestUndoTreeRender(t *testing.T) {
+	tree, _ := newTestTree(t, NewFullSnapshots())
+	tree.Checkpoint("tip")
+	want := strings.Join([]string{
+		`0 ""`,
+		`└── 1 "a"`,
+		`    └── 2 "ab"`,
+		`        ├── 3 "abc"`,
+		`        └── 4 "abd" [tip] <- current`,
+		``,
+	}, "\n")
+	if got := tree.Render(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -1,38 +1,98 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/smtp"
+	"os"
+	"strings"
 )
 
 /*
 Create a system where we have a Subject (the thing being observed)
 and multiple Observers that listen for changes in the Subject.
 When the Subject changes, all registered Observers should be notified.
+
+Observers can fail, so publishing returns a report of which observers
+succeeded and which did not instead of stopping at the first error.
 */
 
 type Observer interface {
-	Update(message string)
+	Update(message string) error
 }
 
+// EmailObserver mails every message through an SMTP server.
 type EmailObserver struct {
+	Addr string
+	From string
+	To   []string
 }
 
-func (EmailObserver) Update(message string) {
-	fmt.Printf("email update: %s", message)
+func (e *EmailObserver) Update(message string) error {
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: email update\r\n\r\n%s\r\n",
+		e.From, strings.Join(e.To, ", "), message)
+	if err := smtp.SendMail(e.Addr, nil, e.From, e.To, []byte(body)); err != nil {
+		return fmt.Errorf("email update: %w", err)
+	}
+	return nil
 }
 
+// LoggingObserver writes every message as a structured log record.
 type LoggingObserver struct {
+	Logger *slog.Logger
 }
 
-func (LoggingObserver) Update(message string) {
-	log.Printf("logging update: %s", message)
+func (l *LoggingObserver) Update(message string) error {
+	l.Logger.Info("logging update", "message", message)
+	return nil
 }
 
 type Subject interface {
 	Adding(o Observer)
 	Removing(o Observer)
-	Notify()
+	Notify() DeliveryReport
+	Publish(message string) DeliveryReport
+}
+
+type DeliveryResult struct {
+	Observer Observer
+	Err      error
+}
+
+// DeliveryReport tells which observers got a published message.
+type DeliveryReport struct {
+	Message string
+	Results []DeliveryResult
+}
+
+func (r DeliveryReport) Succeeded() []Observer {
+	var observers []Observer
+	for _, result := range r.Results {
+		if result.Err == nil {
+			observers = append(observers, result.Observer)
+		}
+	}
+	return observers
+}
+
+func (r DeliveryReport) Failed() []DeliveryResult {
+	var failed []DeliveryResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Err joins the errors of every observer that failed, or is nil.
+func (r DeliveryReport) Err() error {
+	var errs []error
+	for _, result := range r.Failed() {
+		errs = append(errs, fmt.Errorf("%T: %w", result.Observer, result.Err))
+	}
+	return errors.Join(errs...)
 }
 
 type NewMessage struct {
@@ -48,24 +108,54 @@ func (n *NewMessage) Removing(o Observer) {
 	for i, observer := range n.observers {
 		if observer == o {
 			n.observers = append(n.observers[:i], n.observers[i+1:]...)
+			return
 		}
 	}
 }
 
-func (n *NewMessage) Notify() {
+// Notify publishes the current message again.
+func (n *NewMessage) Notify() DeliveryReport {
+	return n.Publish(n.message)
+}
+
+// Publish makes message the current message and delivers it to every
+// observer, even when some of them fail.
+func (n *NewMessage) Publish(message string) DeliveryReport {
+	n.message = message
+	report := DeliveryReport{Message: message}
 	for _, observer := range n.observers {
-		observer.Update(n.message)
+		report.Results = append(report.Results, DeliveryResult{Observer: observer, Err: observer.Update(message)})
 	}
+	return report
 }
 
 func main() {
-	o1 := EmailObserver{}
-	o2 := LoggingObserver{}
+	mailServer, err := NewSMTPStandIn("127.0.0.1:0")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer mailServer.Close()
+
+	o1 := &EmailObserver{Addr: mailServer.Addr(), From: "news@example.com", To: []string{"reader@example.com"}}
+	o2 := &LoggingObserver{Logger: slog.New(slog.NewTextHandler(os.Stdout, nil))}
+	o3 := &EmailObserver{Addr: "127.0.0.1:1", From: "news@example.com", To: []string{"lost@example.com"}}
 
 	newMessage := &NewMessage{
-		observers: []Observer{o1, o2},
+		observers: []Observer{o1, o2, o3},
 		message:   "New message",
 	}
 
-	newMessage.Notify()
+	report := newMessage.Notify()
+	fmt.Printf("delivered to %d observers, %d failed\n", len(report.Succeeded()), len(report.Failed()))
+
+	newMessage.Removing(o3)
+	report = newMessage.Publish("Another message")
+	if err := report.Err(); err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	for _, mail := range mailServer.Messages() {
+		fmt.Printf("mail to %v: %q\n", mail.To, mail.Data)
+	}
 }
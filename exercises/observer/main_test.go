@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordingObserver keeps the messages it gets, or fails with err.
+type recordingObserver struct {
+	messages []string
+	err      error
+}
+
+func (r *recordingObserver) Update(message string) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+func TestPublishReachesEveryObserver(t *testing.T) {
+	mail := newStandIn(t)
+	email := &EmailObserver{Addr: mail.Addr(), From: "news@example.com", To: []string{"reader@example.com"}}
+	var logs bytes.Buffer
+	logging := &LoggingObserver{Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+	errDown := errors.New("down")
+	broken := &recordingObserver{err: errDown}
+	recording := &recordingObserver{}
+
+	subject := &NewMessage{}
+	for _, o := range []Observer{email, broken, logging, recording} {
+		subject.Adding(o)
+	}
+
+	report := subject.Publish("first")
+	if got, want := report.Succeeded(), []Observer{email, logging, recording}; !reflect.DeepEqual(got, want) {
+		t.Errorf("succeeded = %v, want %v", got, want)
+	}
+	if failed := report.Failed(); len(failed) != 1 || failed[0].Observer != broken {
+		t.Errorf("failed = %v, want only the broken observer", failed)
+	}
+	if err := report.Err(); !errors.Is(err, errDown) || !strings.Contains(err.Error(), "*main.recordingObserver") {
+		t.Errorf("Err() = %v", err)
+	}
+	if messages := mail.Messages(); len(messages) != 1 || !strings.Contains(messages[0].Data, "first") {
+		t.Errorf("mail received %v", messages)
+	}
+	if !strings.Contains(logs.String(), "message=first") {
+		t.Errorf("log output %q", logs.String())
+	}
+
+	// Notify publishes the current message again, to the observers left.
+	subject.Removing(broken)
+	subject.Removing(email)
+	report = subject.Notify()
+	if report.Message != "first" || report.Err() != nil || len(report.Results) != 2 {
+		t.Errorf("report = %+v", report)
+	}
+	if want := []string{"first", "first"}; !reflect.DeepEqual(recording.messages, want) {
+		t.Errorf("recording observer got %v, want %v", recording.messages, want)
+	}
+	if len(mail.Messages()) != 1 {
+		t.Error("a removed observer was notified")
+	}
+}
+
+func TestEmailObserverReportsFailure(t *testing.T) {
+	mail := newStandIn(t)
+	addr := mail.Addr()
+	mail.Close()
+
+	o := &EmailObserver{Addr: addr, From: "news@example.com", To: []string{"reader@example.com"}}
+	if err := o.Update("hello"); err == nil || !strings.HasPrefix(err.Error(), "email update: ") {
+		t.Errorf("Update = %v, want a wrapped send error", err)
+	}
+}
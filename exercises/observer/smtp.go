@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// MailMessage is an email received by the SMTP stand-in.
+type MailMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// SMTPStandIn is a tiny local SMTP server that accepts every message and
+// keeps it in memory, so EmailObserver can deliver real mail without an
+// actual mail server.
+type SMTPStandIn struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []MailMessage
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// NewSMTPStandIn listens on addr, e.g. "127.0.0.1:0" for a random port.
+func NewSMTPStandIn(addr string) (*SMTPStandIn, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &SMTPStandIn{listener: listener, conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *SMTPStandIn) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *SMTPStandIn) Messages() []MailMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MailMessage(nil), s.messages...)
+}
+
+// Close stops accepting mail, hangs up on connected clients and waits for
+// their sessions to end.
+func (s *SMTPStandIn) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *SMTPStandIn) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			s.session(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *SMTPStandIn) session(conn *textproto.Conn) {
+	var msg MailMessage
+	conn.PrintfLine("220 localhost SMTP stand-in ready")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO", "EHLO":
+			conn.PrintfLine("250 localhost")
+		case "MAIL":
+			msg = MailMessage{From: address(arg)}
+			conn.PrintfLine("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, address(arg))
+			conn.PrintfLine("250 OK")
+		case "DATA":
+			if msg.From == "" || len(msg.To) == 0 {
+				conn.PrintfLine("503 need MAIL and RCPT first")
+				continue
+			}
+			conn.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := conn.ReadDotBytes()
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				return
+			}
+			msg.Data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			msg = MailMessage{}
+			conn.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			conn.PrintfLine("250 OK")
+		case "QUIT":
+			conn.PrintfLine("221 bye")
+			return
+		default:
+			conn.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+// address extracts the mailbox from "FROM:<a@b>" or "TO:<a@b>".
+func address(arg string) string {
+	_, value, _ := strings.Cut(arg, ":")
+	value = strings.TrimSpace(value)
+	if i := strings.Index(value, ">"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimPrefix(value, "<")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newStandIn(t *testing.T) *SMTPStandIn {
+	t.Helper()
+	s, err := NewSMTPStandIn("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSMTPStandInKeepsMail(t *testing.T) {
+	s := newStandIn(t)
+	to := []string{"a@example.com", "b@example.com"}
+	if err := smtp.SendMail(s.Addr(), nil, "news@example.com", to, []byte("Subject: hi\r\n\r\nhello\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := s.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("kept %d messages, want 1", len(messages))
+	}
+	if m := messages[0]; m.From != "news@example.com" || !reflect.DeepEqual(m.To, to) || !strings.Contains(m.Data, "hello") {
+		t.Errorf("kept %+v", m)
+	}
+}
+
+func TestSMTPStandInCommands(t *testing.T) {
+	s := newStandIn(t)
+	conn, err := textproto.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		command string
+		code    int
+	}{
+		{"HELO test", 250},
+		{"DATA", 503}, // no sender or recipient yet
+		{"VRFY someone", 502},
+		{"MAIL FROM:<news@example.com>", 250},
+		{"DATA", 503}, // still no recipient
+		{"RCPT TO:<a@example.com>", 250},
+		{"RSET", 250},
+		{"QUIT", 221},
+	}
+	for _, step := range steps {
+		id, err := conn.Cmd("%s", step.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.StartResponse(id)
+		code, _, err := conn.ReadResponse(step.code)
+		conn.EndResponse(id)
+		if err != nil {
+			t.Errorf("%s: got %d, want %d", step.command, code, step.code)
+		}
+	}
+	if got := s.Messages(); len(got) != 0 {
+		t.Errorf("kept %v without DATA", got)
+	}
+}
+
+func TestSMTPStandInCloseHangsUpOnClients(t *testing.T) {
+	s, err := NewSMTPStandIn("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Wait for the greeting, so the session is running.
+	if _, err := conn.Read(make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- s.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for an idle client")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Error("the client is still connected after Close")
+	}
+}